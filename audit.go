@@ -0,0 +1,227 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// keyOrigin is where a key of the environment came from.
+type keyOrigin struct {
+	// Source is the dependency which set the key, or "exec.env" for custom
+	// variables of the exec configuration.
+	Source string
+
+	// ModifyIndex is the Consul modify index of the key, and Version is the
+	// version of a Vault KV2 secret. They are zero when not available.
+	ModifyIndex uint64
+	Version     int
+
+	// RawKey is the key as returned by the source, before Transforms were
+	// applied to it.
+	RawKey     string
+	Transforms []string
+
+	// secret is true if the value came from a secret.
+	secret bool
+}
+
+// transform records that the named transformation changed the key from before
+// to after.
+func (o *keyOrigin) transform(name, before, after string) {
+	if before != after {
+		o.Transforms = append(o.Transforms, fmt.Sprintf("%s: %s -> %s", name, before, after))
+	}
+}
+
+// auditSourceExec is the source of keys set by the custom variables of the
+// exec configuration.
+const auditSourceExec = "exec.env"
+
+// setOrigin records where the key set by the dependency came from. The
+// caller must hold the dependencies lock.
+func (r *Runner) setOrigin(d dep.Dependency, key string, origin *keyOrigin) {
+	origins, ok := r.origins[d.String()]
+	if !ok {
+		origins = make(map[string]*keyOrigin)
+		r.origins[d.String()] = origins
+	}
+	origins[key] = origin
+}
+
+// secretVersion returns the version of a Vault KV2 secret, or 0.
+func secretVersion(s *dep.Secret) int {
+	if s == nil || !isVaultKv2(s.Data) {
+		return 0
+	}
+	metadata, _ := s.Data["metadata"].(map[string]interface{})
+	switch typed := metadata["version"].(type) {
+	case json.Number:
+		v, _ := strconv.Atoi(typed.String())
+		return v
+	case float64:
+		return int(typed)
+	case int:
+		return typed
+	}
+	return 0
+}
+
+// keyOrigins returns where each key of the environment came from, given the
+// environment of each dependency. Later dependencies override earlier ones,
+// and custom variables override all of them, the same as when the environment
+// is merged. The caller must hold the dependencies lock.
+func (r *Runner) keyOrigins(
+	depEnv map[string]map[string]string, env map[string]string,
+) map[string]*keyOrigin {
+	result := make(map[string]*keyOrigin, len(env))
+	for _, d := range r.dependencies {
+		id := d.String()
+		_, secret := d.(*dep.VaultReadQuery)
+		for k := range depEnv[id] {
+			origin, ok := r.origins[id][k]
+			if !ok {
+				origin = &keyOrigin{Source: id, RawKey: k}
+			}
+			origin.secret = secret
+			result[k] = origin
+		}
+	}
+
+	// Custom variables of the exec configuration take precedence.
+	for _, v := range r.config.Exec.Env.Custom {
+		k := strings.SplitN(v, "=", 2)[0]
+		result[k] = &keyOrigin{Source: auditSourceExec, RawKey: k}
+	}
+	for k := range result {
+		if _, ok := env[k]; !ok {
+			delete(result, k)
+		}
+	}
+	return result
+}
+
+// auditChange is a key which was added, removed or changed.
+type auditChange struct {
+	Key         string  `json:"key"`
+	Source      string  `json:"source"`
+	ModifyIndex uint64  `json:"modify_index,omitempty"`
+	Version     int     `json:"version,omitempty"`
+	Old         *string `json:"old,omitempty"`
+	New         *string `json:"new,omitempty"`
+}
+
+// auditEvent lists the keys which were added, removed and changed by a run.
+type auditEvent struct {
+	Time    time.Time      `json:"time"`
+	Added   []*auditChange `json:"added,omitempty"`
+	Removed []*auditChange `json:"removed,omitempty"`
+	Changed []*auditChange `json:"changed,omitempty"`
+}
+
+// newAuditEvent returns the event for the change of the environment from prev
+// to cur, with redacted values. Removed keys carry their previous source.
+func (r *Runner) newAuditEvent(
+	prev, cur map[string]string, prevOrigins, curOrigins map[string]*keyOrigin,
+) *auditEvent {
+	originOf := func(origins map[string]*keyOrigin, key string) *keyOrigin {
+		if origin, ok := origins[key]; ok {
+			return origin
+		}
+		return &keyOrigin{}
+	}
+	value := func(key, v string, origin *keyOrigin) *string {
+		v = r.redactor.audit(key, v, origin.secret)
+		return &v
+	}
+	change := func(key string, origin *keyOrigin) *auditChange {
+		return &auditChange{
+			Key:         key,
+			Source:      origin.Source,
+			ModifyIndex: origin.ModifyIndex,
+			Version:     origin.Version,
+		}
+	}
+
+	event := &auditEvent{Time: time.Now().UTC()}
+	for _, k := range sortedKeys(cur) {
+		origin := originOf(curOrigins, k)
+		pv, ok := prev[k]
+		switch {
+		case !ok:
+			c := change(k, origin)
+			c.New = value(k, cur[k], origin)
+			event.Added = append(event.Added, c)
+		case pv != cur[k]:
+			c := change(k, origin)
+			c.Old = value(k, pv, originOf(prevOrigins, k))
+			c.New = value(k, cur[k], origin)
+			event.Changed = append(event.Changed, c)
+		}
+	}
+	for _, k := range sortedKeys(prev) {
+		if _, ok := cur[k]; ok {
+			continue
+		}
+		origin := originOf(prevOrigins, k)
+		c := change(k, origin)
+		c.Old = value(k, prev[k], origin)
+		event.Removed = append(event.Removed, c)
+	}
+	return event
+}
+
+// empty returns true if the event has no changes.
+func (e *auditEvent) empty() bool {
+	return len(e.Added) == 0 && len(e.Removed) == 0 && len(e.Changed) == 0
+}
+
+// audit logs the event, and appends it to the audit file when one is
+// configured. Failing to write the file is only logged, since it must not stop
+// the child process from getting the new environment.
+func (r *Runner) audit(event *auditEvent) {
+	logger := namedLogger("audit")
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("could not encode audit event:", err)
+		return
+	}
+	logger.Info(fmt.Sprintf("environment changed: %d added, %d removed, %d "+
+		"changed: %s", len(event.Added), len(event.Removed), len(event.Changed), b))
+
+	if !r.config.Audit.Enabled() {
+		return
+	}
+	if err := appendAudit(r.config.Audit, b); err != nil {
+		logger.Error("could not write audit event:", err)
+	}
+}
+
+// appendAudit appends the encoded event as a line to the audit file.
+func appendAudit(c *AuditConfig, b []byte) error {
+	path := config.StringVal(c.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		config.FileModeVal(c.Perms))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,170 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestSecretVersion(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		s    *dep.Secret
+		exp  int
+	}{
+		{"nil", nil, 0},
+		{"kv1", &dep.Secret{Data: map[string]interface{}{"a": "b"}}, 0},
+		{
+			"kv2",
+			&dep.Secret{Data: map[string]interface{}{
+				"data":     map[string]interface{}{"a": "b"},
+				"metadata": map[string]interface{}{"version": json.Number("3")},
+			}},
+			3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := secretVersion(tc.s); act != tc.exp {
+				t.Errorf("expected %d, got %d", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_Run_audit(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit", "events.jsonl")
+	c := DefaultConfig().Merge(&Config{
+		Audit: &AuditConfig{
+			Path: config.String(path),
+		},
+		Print: &PrintConfig{
+			Format: config.String(PrintFormatDotenv),
+			Path:   config.String(filepath.Join(t.TempDir(), "app.env")),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := func(password string, version string) *dep.Secret {
+		return &dep.Secret{Data: map[string]interface{}{
+			"data":     map[string]interface{}{"password": password},
+			"metadata": map[string]interface{}{"version": json.Number(version)},
+		}}
+	}
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{
+		{Key: "host", Value: "a", ModifyIndex: 10},
+		{Key: "port", Value: "80", ModifyIndex: 11},
+	})
+	r.Receive(r.dependencies[1], secret("hunter2", "1"))
+	if _, err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{
+		{Key: "host", Value: "b", ModifyIndex: 12},
+	})
+	r.Receive(r.dependencies[1], secret("hunter3", "2"))
+	if _, err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []*auditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, &event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	str := func(s string) *string { return &s }
+	if exp := []*auditChange{
+		{Key: "host", Source: "kv.list(app)", ModifyIndex: 10, New: str("a")},
+		{Key: "password", Source: "vault.read(secret/app)", Version: 1, New: str(redactedMarker)},
+		{Key: "port", Source: "kv.list(app)", ModifyIndex: 11, New: str("80")},
+	}; !reflect.DeepEqual(events[0].Added, exp) {
+		t.Errorf("bad added: %s", mustJSON(t, events[0].Added))
+	}
+
+	if exp := []*auditChange{
+		{Key: "host", Source: "kv.list(app)", ModifyIndex: 12, Old: str("a"), New: str("b")},
+		{Key: "password", Source: "vault.read(secret/app)", Version: 2, Old: str(redactedMarker), New: str(redactedMarker)},
+	}; !reflect.DeepEqual(events[1].Changed, exp) {
+		t.Errorf("bad changed: %s", mustJSON(t, events[1].Changed))
+	}
+	if exp := []*auditChange{
+		{Key: "port", Source: "kv.list(app)", ModifyIndex: 11, Old: str("80")},
+	}; !reflect.DeepEqual(events[1].Removed, exp) {
+		t.Errorf("bad removed: %s", mustJSON(t, events[1].Removed))
+	}
+}
+
+func TestRunner_newAuditEvent_unredacted(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{LogUnredacted: config.Bool(true)})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// log_unredacted only applies to log output, never to audit events.
+	event := r.newAuditEvent(nil,
+		map[string]string{"password": "hunter2", "DB_PASSWORD": "hunter3", "host": "a"},
+		nil,
+		map[string]*keyOrigin{"password": {Source: "vault.read(secret/app)", secret: true}},
+	)
+	str := func(s string) *string { return &s }
+	if exp := []*auditChange{
+		{Key: "DB_PASSWORD", New: str(redactedMarker)},
+		{Key: "host", New: str("a")},
+		{Key: "password", Source: "vault.read(secret/app)", New: str(redactedMarker)},
+	}; !reflect.DeepEqual(event.Added, exp) {
+		t.Errorf("bad added: %s", mustJSON(t, event.Added))
+	}
+	if act := r.redactor.value("password", "hunter2", true); act != "hunter2" {
+		t.Errorf("expected the log value to be unredacted, got %q", act)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
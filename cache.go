@@ -0,0 +1,303 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul-template/renderer"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// cacheVersion is the version of the cache file format.
+const cacheVersion = 2
+
+const (
+	// cacheSaltSize is the size of the random salt of each cache file.
+	cacheSaltSize = 16
+
+	// cacheScryptN, cacheScryptR and cacheScryptP are the scrypt parameters
+	// used to derive the encryption key from the cache key.
+	cacheScryptN = 1 << 15
+	cacheScryptR = 8
+	cacheScryptP = 1
+)
+
+// cacheFile is the contents of the cache file. Salt is the salt the key which
+// encrypts secrets is derived with, and is only set when secrets are cached.
+type cacheFile struct {
+	Version int                    `json:"version"`
+	Salt    []byte                 `json:"salt,omitempty"`
+	Entries map[string]*cacheEntry `json:"entries"`
+}
+
+// cacheEntry is the cached data of a dependency. The data of secrets is
+// encrypted and stored in Encrypted instead of Data.
+type cacheEntry struct {
+	Updated   time.Time       `json:"updated"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Encrypted []byte          `json:"encrypted,omitempty"`
+}
+
+// loadCacheKey reads the cache key, from which the key used to encrypt secrets
+// in the cache is derived, from the key file or environment variable. It
+// returns nil if there is no key.
+func loadCacheKey(c *CacheConfig) ([]byte, error) {
+	var key string
+	if path := config.StringVal(c.KeyFile); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading cache key file")
+		}
+		key = strings.TrimSpace(string(b))
+		if key == "" {
+			return nil, fmt.Errorf("cache key file %q is empty", path)
+		}
+	} else {
+		key = os.Getenv(config.StringVal(c.KeyEnv))
+	}
+
+	if key == "" {
+		return nil, nil
+	}
+	return []byte(key), nil
+}
+
+// deriveCacheKey derives the key used to encrypt secrets in the cache from the
+// cache key and the salt of the cache file.
+func deriveCacheKey(key, salt []byte) ([]byte, error) {
+	return scrypt.Key(key, salt, cacheScryptN, cacheScryptR, cacheScryptP, 32)
+}
+
+// cacheCipherKey returns the key used to encrypt secrets in the cache file
+// with the given salt, which is derived once per salt. It returns nil if there
+// is no cache key.
+func (r *Runner) cacheCipherKey(salt []byte) ([]byte, error) {
+	if r.cacheKey == nil {
+		return nil, nil
+	}
+	if r.cacheDerivedKey == nil || !bytes.Equal(r.cacheSalt, salt) {
+		key, err := deriveCacheKey(r.cacheKey, salt)
+		if err != nil {
+			return nil, errors.Wrap(err, "deriving cache key")
+		}
+		r.cacheSalt, r.cacheDerivedKey = salt, key
+	}
+	return r.cacheDerivedKey, nil
+}
+
+// encryptCache encrypts the data of the dependency with the given id. The id
+// is authenticated, so an entry cannot be moved to another dependency.
+func encryptCache(key []byte, id string, data []byte) ([]byte, error) {
+	gcm, err := cacheCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, []byte(id)), nil
+}
+
+// decryptCache decrypts data encrypted by encryptCache.
+func decryptCache(key []byte, id string, data []byte) ([]byte, error) {
+	gcm, err := cacheCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is too short")
+	}
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, []byte(id))
+}
+
+func cacheCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decodeCacheData decodes the cached data into the type the watcher returns
+// for the dependency.
+func decodeCacheData(d dep.Dependency, b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	switch d.(type) {
+	case *dep.KVListQuery:
+		var data []*dep.KeyPair
+		err := dec.Decode(&data)
+		return data, err
+	case *dep.VaultReadQuery:
+		var data *dep.Secret
+		err := dec.Decode(&data)
+		return data, err
+	case *dep.CatalogServiceQuery:
+		var data []*dep.CatalogService
+		err := dec.Decode(&data)
+		return data, err
+	case *dep.HealthServiceQuery:
+		var data []*dep.HealthService
+		err := dec.Decode(&data)
+		return data, err
+	}
+	return nil, fmt.Errorf("unknown dependency type %T", d)
+}
+
+// writeCache writes the data of every dependency to the cache file. Entries
+// which were loaded from the cache and have not been replaced by live data
+// are kept as they are. The caller must hold the dependencies lock.
+func (r *Runner) writeCache() error {
+	file := &cacheFile{
+		Version: cacheVersion,
+		Entries: make(map[string]*cacheEntry, len(r.dependencies)),
+	}
+
+	// The salt of the cache file is kept, so that the entries loaded from it
+	// can still be decrypted.
+	var cipherKey []byte
+	if r.cacheKey != nil {
+		file.Salt = r.cacheSalt
+		if file.Salt == nil {
+			file.Salt = make([]byte, cacheSaltSize)
+			if _, err := io.ReadFull(rand.Reader, file.Salt); err != nil {
+				return err
+			}
+		}
+		var err error
+		if cipherKey, err = r.cacheCipherKey(file.Salt); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range r.dependencies {
+		id := d.String()
+		data, ok := r.data[id]
+		if !ok || r.cachedDeps[id] {
+			if entry, ok := r.cacheEntries[id]; ok {
+				file.Entries[id] = entry
+			}
+			continue
+		}
+
+		b, err := json.Marshal(data)
+		if err != nil {
+			return errors.Wrap(err, id)
+		}
+
+		entry := &cacheEntry{Updated: time.Now().UTC()}
+		if _, ok := d.(*dep.VaultReadQuery); ok {
+			// Secrets are only cached when they can be encrypted.
+			if cipherKey == nil {
+				continue
+			}
+			if entry.Encrypted, err = encryptCache(cipherKey, id, b); err != nil {
+				return errors.Wrap(err, id)
+			}
+		} else {
+			entry.Data = b
+		}
+		file.Entries[id] = entry
+	}
+	r.cacheEntries = file.Entries
+
+	contents, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	path := config.StringVal(r.config.Cache.Path)
+	namedLogger("runner").Debug("writing cache to", path)
+	_, err = renderer.Render(&renderer.RenderInput{
+		Contents:       contents,
+		CreateDestDirs: true,
+		Path:           path,
+		Perms:          config.FileModeVal(r.config.Cache.Perms),
+	})
+	return err
+}
+
+// loadCache gives the cached data to every dependency which has not returned
+// live data, and returns how many were loaded.
+func (r *Runner) loadCache() (int, error) {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	path := config.StringVal(r.config.Cache.Path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "reading cache")
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return 0, errors.Wrap(err, "decoding cache")
+	}
+	if file.Version != cacheVersion {
+		return 0, fmt.Errorf("unknown cache version %d", file.Version)
+	}
+	r.cacheEntries = file.Entries
+
+	logger := namedLogger("runner")
+	var cipherKey []byte
+	if file.Salt != nil {
+		if cipherKey, err = r.cacheCipherKey(file.Salt); err != nil {
+			return 0, err
+		}
+	}
+
+	loaded := 0
+	for _, d := range r.dependencies {
+		id := d.String()
+		entry, ok := file.Entries[id]
+		if _, live := r.data[id]; live || !ok {
+			continue
+		}
+
+		raw := []byte(entry.Data)
+		if entry.Encrypted != nil {
+			if cipherKey == nil {
+				logger.Warn(fmt.Sprintf("cannot use cached %s without a cache key", id))
+				continue
+			}
+			if raw, err = decryptCache(cipherKey, id, entry.Encrypted); err != nil {
+				logger.Warn(fmt.Sprintf("cannot decrypt cached %s: %s", id, err))
+				continue
+			}
+		}
+
+		data, err := decodeCacheData(d, raw)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("cannot decode cached %s: %s", id, err))
+			continue
+		}
+
+		typ, path := r.source(d)
+		logger.Warn(fmt.Sprintf("no live data for %s %q, starting from the "+
+			"cache updated at %s", typ, path, entry.Updated.Format(time.RFC3339)))
+		r.data[id] = data
+		r.cachedDeps[id] = true
+		loaded++
+	}
+	return loaded, nil
+}
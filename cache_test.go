@@ -0,0 +1,189 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestEncryptCache(t *testing.T) {
+	t.Parallel()
+
+	key := make([]byte, 32)
+	b, err := encryptCache(key, "vault.read(secret/foo)", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("hunter2")) {
+		t.Fatal("expected data to be encrypted")
+	}
+
+	out, err := decryptCache(key, "vault.read(secret/foo)", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hunter2" {
+		t.Errorf("bad data: %q", out)
+	}
+
+	if _, err := decryptCache(key, "vault.read(secret/bar)", b); err == nil {
+		t.Error("expected error decrypting for another dependency")
+	}
+	if _, err := decryptCache(make([]byte, 16), "vault.read(secret/foo)", b); err == nil {
+		t.Error("expected error decrypting with another key")
+	}
+}
+
+func TestDeriveCacheKey(t *testing.T) {
+	t.Parallel()
+
+	salt := bytes.Repeat([]byte{1}, cacheSaltSize)
+	key, err := deriveCacheKey([]byte("s3cr3t"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("expected a 32 byte key, got %d bytes", len(key))
+	}
+
+	again, err := deriveCacheKey([]byte("s3cr3t"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Error("expected the same key for the same salt")
+	}
+
+	other, err := deriveCacheKey([]byte("s3cr3t"), bytes.Repeat([]byte{2}, cacheSaltSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key, other) {
+		t.Error("expected another key for another salt")
+	}
+}
+
+func TestRunner_cache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache.json")
+	keyPath := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyPath, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	newRunner := func(t *testing.T) (*Runner, *bytes.Buffer) {
+		c := DefaultConfig().Merge(&Config{
+			Cache: &CacheConfig{
+				Path:    config.String(cachePath),
+				KeyFile: config.String(keyPath),
+			},
+			Print: &PrintConfig{
+				Format: config.String(PrintFormatDotenv),
+			},
+			Prefixes: &PrefixConfigs{
+				&PrefixConfig{Path: config.String("app")},
+			},
+			Secrets: &PrefixConfigs{
+				&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+			},
+		})
+		r, err := NewRunner(c, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		r.outStream = &buf
+		return r, &buf
+	}
+
+	live, liveOut := newRunner(t)
+	live.Receive(live.dependencies[0], []*dep.KeyPair{{Key: "HOST", Value: "a"}})
+	live.Receive(live.dependencies[1], &dep.Secret{
+		Data: map[string]interface{}{"password": "hunter2"},
+	})
+	if _, err := live.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "hunter2") {
+		t.Fatal("expected secret to be encrypted in the cache")
+	}
+	if !strings.Contains(string(b), `"salt":`) {
+		t.Errorf("expected the salt in the cache, got %s", b)
+	}
+	stat, err := os.Stat(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != DefaultCachePerms {
+		t.Errorf("bad perms: %s", stat.Mode())
+	}
+
+	cached, cachedOut := newRunner(t)
+	loaded, err := cached.loadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 {
+		t.Fatalf("expected 2 dependencies loaded from the cache, got %d", loaded)
+	}
+	if _, err := cached.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if cachedOut.String() != liveOut.String() {
+		t.Errorf("expected %q, got %q", liveOut.String(), cachedOut.String())
+	}
+
+	// Live data replaces the cached data, and cached entries are kept when
+	// the cache is written again.
+	cached.Receive(cached.dependencies[0], []*dep.KeyPair{{Key: "HOST", Value: "b"}})
+	if cached.cachedDeps["kv.list(app)"] {
+		t.Error("expected prefix to use live data")
+	}
+	if !cached.cachedDeps["vault.read(secret/app)"] {
+		t.Error("expected secret to use cached data")
+	}
+	if _, err := cached.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if entry := cached.cacheEntries["vault.read(secret/app)"]; entry == nil || entry.Encrypted == nil {
+		t.Error("expected cached secret to be kept")
+	}
+
+	// The kept secret is still encrypted with the salt of the cache file.
+	reloaded, _ := newRunner(t)
+	if loaded, err := reloaded.loadCache(); err != nil || loaded != 2 {
+		t.Errorf("expected 2 dependencies loaded from the cache, got %d (%v)", loaded, err)
+	}
+}
+
+func TestRunner_childEnvMap_cacheKey(t *testing.T) {
+	t.Setenv(DefaultCacheKeyEnv, "s3cr3t")
+	t.Setenv("ENVCONSUL_TEST_PARENT", "yes")
+
+	r, err := NewRunner(DefaultConfig(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := r.childEnvMap(nil)
+	if _, ok := env[DefaultCacheKeyEnv]; ok {
+		t.Errorf("expected %s not to be passed to the child", DefaultCacheKeyEnv)
+	}
+	if env["ENVCONSUL_TEST_PARENT"] != "yes" {
+		t.Errorf("expected the rest of the environment, got %v", env)
+	}
+}
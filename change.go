@@ -0,0 +1,153 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"sort"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul-template/renderer"
+)
+
+const (
+	// ChangeStrategyRestart stops the child process and starts a new one with
+	// the new environment. This is the default.
+	ChangeStrategyRestart = "restart"
+
+	// ChangeStrategySignal writes the new environment to the env file and
+	// sends the exec reload signal to the running child process.
+	ChangeStrategySignal = "signal"
+
+	// ChangeStrategyExit stops the child process and envconsul, so that an
+	// outer orchestrator can reschedule it with the new environment.
+	ChangeStrategyExit = "exit"
+)
+
+// ChangeStrategies is the list of all supported change strategies.
+var ChangeStrategies = []string{
+	ChangeStrategyRestart,
+	ChangeStrategySignal,
+	ChangeStrategyExit,
+}
+
+// changeStrategyRank orders the strategies from least to most disruptive.
+// When several sources change at once, the most disruptive strategy wins.
+var changeStrategyRank = map[string]int{
+	ChangeStrategySignal:  1,
+	ChangeStrategyRestart: 2,
+	ChangeStrategyExit:    3,
+}
+
+// validChangeStrategy returns true if the given strategy is a known change
+// strategy.
+func validChangeStrategy(s string) bool {
+	_, ok := changeStrategyRank[s]
+	return ok
+}
+
+// ErrEnvChanged is returned by the runner when the environment changed and the
+// change strategy is to exit.
+type ErrEnvChanged struct{}
+
+func (e *ErrEnvChanged) Error() string {
+	return "environment changed, exiting"
+}
+
+// ExitStatus implements the manager.ErrExitable interface.
+func (e *ErrEnvChanged) ExitStatus() int {
+	return ExitCodeEnvChanged
+}
+
+// changeStrategy returns the strategy for the given environment change, given
+// the environment contributed by each dependency in this run and the last.
+// Only dependencies where one of the given keys changed are considered.
+func (r *Runner) changeStrategy(prev, cur map[string]map[string]string, keys map[string]bool) string {
+	strategy := ""
+	for id, env := range cur {
+		relevant := false
+		for _, k := range changedKeys(prev[id], env) {
+			if keys[k] {
+				relevant = true
+				break
+			}
+		}
+		if !relevant {
+			continue
+		}
+		s := r.changeStrategies[id]
+		if changeStrategyRank[s] > changeStrategyRank[strategy] {
+			strategy = s
+		}
+	}
+
+	if strategy == "" {
+		strategy = config.StringVal(r.config.ChangeStrategy)
+	}
+	return strategy
+}
+
+// changedKeys returns the sorted keys which were added, removed or changed
+// between the two environments.
+func changedKeys(prev, cur map[string]string) []string {
+	var keys []string
+	for k, v := range cur {
+		if pv, ok := prev[k]; !ok || pv != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range prev {
+		if _, ok := cur[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// restartKeys returns the set of the given changed keys which should restart,
+// signal or stop the child process, according to the restart_on and
+// ignore_changes globs.
+func (r *Runner) restartKeys(changed []string) map[string]bool {
+	keys := make(map[string]bool, len(changed))
+	for _, k := range changed {
+		if anyGlobMatch(k, r.config.IgnoreChanges) {
+			continue
+		}
+		if len(r.config.RestartOn) > 0 && !anyGlobMatch(k, r.config.RestartOn) {
+			continue
+		}
+		keys[k] = true
+	}
+	return keys
+}
+
+// writeEnvFile writes the environment to the configured env file.
+func (r *Runner) writeEnvFile(env map[string]string) error {
+	contents, err := formatEnv(config.StringVal(r.config.EnvFile.Format), env)
+	if err != nil {
+		return err
+	}
+
+	path := config.StringVal(r.config.EnvFile.Path)
+	namedLogger("runner").Debug("writing environment to", path)
+	_, err = renderer.Render(&renderer.RenderInput{
+		Contents:       contents,
+		CreateDestDirs: true,
+		Path:           path,
+		Perms:          config.FileModeVal(r.config.EnvFile.Perms),
+	})
+	return err
+}
+
+// addChangeStrategy records the change strategy of the given dependency,
+// falling back to the top-level strategy when the source does not set one.
+// Strategies are checked when the configuration is validated.
+func (r *Runner) addChangeStrategy(d dep.Dependency, s *string) {
+	strategy := config.StringVal(r.config.ChangeStrategy)
+	if config.StringPresent(s) {
+		strategy = config.StringVal(s)
+	}
+	r.changeStrategies[d.String()] = strategy
+}
@@ -0,0 +1,349 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRunner_changeStrategy(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		ChangeStrategy: config.String(ChangeStrategySignal),
+		EnvFile: &EnvFileConfig{
+			Path: config.String(filepath.Join(t.TempDir(), "app.env")),
+		},
+		Exec: &config.ExecConfig{
+			ReloadSignal: config.Signal(syscall.SIGUSR1),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("signal")},
+			&PrefixConfig{Path: config.String("restart"), ChangeStrategy: config.String(ChangeStrategyRestart)},
+			&PrefixConfig{Path: config.String("exit"), ChangeStrategy: config.String(ChangeStrategyExit)},
+		},
+	})
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := map[string]map[string]string{
+		"kv.list(signal)":  {"A": "1"},
+		"kv.list(restart)": {"B": "1"},
+		"kv.list(exit)":    {"C": "1"},
+	}
+
+	cases := []struct {
+		name    string
+		changed []string
+		exp     string
+	}{
+		{"signal", []string{"kv.list(signal)"}, ChangeStrategySignal},
+		{"restart", []string{"kv.list(restart)"}, ChangeStrategyRestart},
+		{"restart_wins", []string{"kv.list(signal)", "kv.list(restart)"}, ChangeStrategyRestart},
+		{"exit_wins", []string{"kv.list(signal)", "kv.list(restart)", "kv.list(exit)"}, ChangeStrategyExit},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cur := make(map[string]map[string]string)
+			for id, env := range prev {
+				cur[id] = env
+			}
+			for _, id := range tc.changed {
+				cur[id] = map[string]string{"CHANGED": "1"}
+			}
+			keys := map[string]bool{"A": true, "B": true, "C": true, "CHANGED": true}
+			if act := r.changeStrategy(prev, cur, keys); act != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_changeStrategyValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		config *Config
+	}{
+		{
+			"unknown",
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("foo"), ChangeStrategy: config.String("reboot")},
+				},
+			},
+		},
+		{
+			"signal_without_reload_signal",
+			&Config{
+				EnvFile: &EnvFileConfig{Path: config.String("/tmp/app.env")},
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("foo"), ChangeStrategy: config.String(ChangeStrategySignal)},
+				},
+			},
+		},
+		{
+			"signal_without_env_file",
+			&Config{
+				Exec: &config.ExecConfig{ReloadSignal: config.Signal(syscall.SIGUSR1)},
+				Services: &ServiceConfigs{
+					&ServiceConfig{Query: config.String("foo"), ChangeStrategy: config.String(ChangeStrategySignal)},
+				},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewRunner(DefaultConfig().Merge(tc.config), true); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestRunner_Run_changeStrategy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "app.env")
+	marker := filepath.Join(dir, "reloaded")
+	ready := filepath.Join(dir, "ready")
+
+	c := DefaultConfig().Merge(&Config{
+		EnvFile: &EnvFileConfig{
+			Path: config.String(envFile),
+		},
+		Exec: &config.ExecConfig{
+			Command: []string{"sh", "-c",
+				`trap 'echo reloaded >> "` + marker + `"' USR1; touch "` + ready + `"; ` +
+					`while :; do sleep 0.05; done`},
+			ReloadSignal: config.Signal(syscall.SIGUSR1),
+			KillTimeout:  config.TimeDuration(time.Second),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app"), ChangeStrategy: config.String(ChangeStrategySignal)},
+			&PrefixConfig{Path: config.String("db")},
+			&PrefixConfig{Path: config.String("infra"), ChangeStrategy: config.String(ChangeStrategyExit)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	run := func(app, db, infra string) (<-chan int, error) {
+		r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "APP", Value: app}})
+		r.Receive(r.dependencies[1], []*dep.KeyPair{{Key: "DB", Value: db}})
+		r.Receive(r.dependencies[2], []*dep.KeyPair{{Key: "INFRA", Value: infra}})
+		return r.Run()
+	}
+
+	exitCh, err := run("1", "1", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh == nil {
+		t.Fatal("expected child to start")
+	}
+	pid := r.child.Pid()
+
+	// Wait for the trap to be installed, since the signal would otherwise
+	// stop the child.
+	waitFile := func(path, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := os.Stat(path); err == nil {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	waitFile(ready, "child did not start")
+
+	// A change to the signal source keeps the child and signals it.
+	exitCh, err = run("2", "1", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh != nil || r.child.Pid() != pid {
+		t.Fatal("expected child to keep running")
+	}
+	b, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "APP=2\n") {
+		t.Errorf("expected env file to be updated, got %q", string(b))
+	}
+	waitFile(marker, "child did not receive the reload signal")
+
+	// A change to the restart source starts a new child.
+	exitCh, err = run("2", "2", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh == nil || r.child.Pid() == pid {
+		t.Fatal("expected a new child")
+	}
+
+	// A change to the exit source returns an exitable error.
+	_, err = run("2", "2", "2")
+	if _, ok := err.(*ErrEnvChanged); !ok {
+		t.Fatalf("expected ErrEnvChanged, got %v", err)
+	}
+}
+
+func TestRunner_restartKeys(t *testing.T) {
+	t.Parallel()
+
+	changed := []string{"APP_HOST", "APP_VERSION", "DB_PASSWORD", "LOG_LEVEL"}
+
+	cases := []struct {
+		name          string
+		restartOn     []string
+		ignoreChanges []string
+		exp           []string
+	}{
+		{
+			"all",
+			nil,
+			nil,
+			[]string{"APP_HOST", "APP_VERSION", "DB_PASSWORD", "LOG_LEVEL"},
+		},
+		{
+			"ignore_changes",
+			nil,
+			[]string{"*_VERSION", "LOG_*"},
+			[]string{"APP_HOST", "DB_PASSWORD"},
+		},
+		{
+			"restart_on",
+			[]string{"APP_*"},
+			nil,
+			[]string{"APP_HOST", "APP_VERSION"},
+		},
+		{
+			"both",
+			[]string{"APP_*"},
+			[]string{"*_VERSION"},
+			[]string{"APP_HOST"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{
+				RestartOn:     tc.restartOn,
+				IgnoreChanges: tc.ignoreChanges,
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := r.restartKeys(changed)
+			act := make([]string, 0, len(keys))
+			for k := range keys {
+				act = append(act, k)
+			}
+			sort.Strings(act)
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("expected %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_Run_filteredChanges(t *testing.T) {
+	t.Parallel()
+
+	envFile := filepath.Join(t.TempDir(), "app.env")
+
+	c := DefaultConfig().Merge(&Config{
+		EnvFile: &EnvFileConfig{
+			Path: config.String(envFile),
+		},
+		Exec: &config.ExecConfig{
+			Command:     []string{"sh", "-c", "while :; do sleep 0.05; done"},
+			KillTimeout: config.TimeDuration(time.Second),
+			Env: &config.EnvConfig{
+				Denylist: []string{"SECRET_*"},
+			},
+		},
+		IgnoreChanges: []string{"*_VERSION"},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	run := func(pairs ...string) <-chan int {
+		var kvs []*dep.KeyPair
+		for i := 0; i < len(pairs); i += 2 {
+			kvs = append(kvs, &dep.KeyPair{Key: pairs[i], Value: pairs[i+1]})
+		}
+		r.Receive(r.dependencies[0], kvs)
+		exitCh, err := r.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exitCh
+	}
+
+	if exitCh := run("HOST", "a", "APP_VERSION", "1", "SECRET_KEY", "x"); exitCh == nil {
+		t.Fatal("expected child to start")
+	}
+	pid := r.child.Pid()
+
+	// A key removed by the denylist never reaches the child.
+	if exitCh := run("HOST", "a", "APP_VERSION", "1", "SECRET_KEY", "y"); exitCh != nil {
+		t.Fatal("expected denylisted change to be ignored")
+	}
+
+	// An ignored key updates the env file, but keeps the child.
+	if exitCh := run("HOST", "a", "APP_VERSION", "2", "SECRET_KEY", "y"); exitCh != nil {
+		t.Fatal("expected ignored change to keep the child")
+	}
+	b, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "APP_VERSION=2\nHOST=a\n" {
+		t.Errorf("bad env file: %q", string(b))
+	}
+	if r.child.Pid() != pid {
+		t.Fatal("expected child to keep running")
+	}
+
+	// Any other key restarts the child.
+	if exitCh := run("HOST", "b", "APP_VERSION", "2", "SECRET_KEY", "y"); exitCh == nil {
+		t.Fatal("expected child to restart")
+	}
+	if r.child.Pid() == pid {
+		t.Fatal("expected a new child")
+	}
+}
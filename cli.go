@@ -34,6 +34,9 @@ const (
 	ExitCodeParseFlagsError
 	ExitCodeRunnerError
 	ExitCodeConfigError
+	ExitCodeEnvChanged
+	ExitCodeStartupTimeout
+	ExitCodeNotReady
 )
 
 // ErrMissingCommand is returned when no command is specified.
@@ -75,6 +78,16 @@ func NewCLI(out, err io.Writer) *CLI {
 // Run accepts a slice of arguments and returns an int representing the exit
 // status from the command.
 func (cli *CLI) Run(args []string) int {
+	// Subcommands are given before any flags.
+	if len(args) > 1 {
+		switch args[1] {
+		case "explain":
+			return cli.runExplain(args[2:])
+		case "validate":
+			return cli.runValidate(args[2:])
+		}
+	}
+
 	// Parse the flags and args
 	cfg, paths, once, isVersion, err := cli.ParseFlags(args[1:])
 	if err != nil {
@@ -97,6 +110,10 @@ func (cli *CLI) Run(args []string) int {
 
 	cfg.Finalize()
 
+	if err := cfg.Validate(); err != nil {
+		return logError(err, ExitCodeConfigError)
+	}
+
 	// Setup the config and logging
 	err = cli.setupLogger(cfg)
 	if err != nil {
@@ -113,8 +130,9 @@ func (cli *CLI) Run(args []string) int {
 		return ExitCodeOK
 	}
 
-	// Return an error if no command was given
-	if cfg.Exec.Command.Empty() {
+	// Return an error if no command was given. A command is not needed when
+	// only printing the environment.
+	if cfg.Exec.Command.Empty() && !cfg.Print.Enabled() {
 		return logError(ErrMissingCommand, ExitCodeConfigError)
 	}
 
@@ -163,6 +181,7 @@ func (cli *CLI) Run(args []string) int {
 			switch s {
 			case *cfg.ReloadSignal:
 				fmt.Fprintf(cli.errStream, "Reloading configuration...\n")
+				runner.notifyReloading()
 				runner.Stop()
 
 				// Re-parse any configuration files or paths
@@ -172,6 +191,10 @@ func (cli *CLI) Run(args []string) int {
 				}
 				cfg.Finalize()
 
+				if err := cfg.Validate(); err != nil {
+					return logError(err, ExitCodeConfigError)
+				}
+
 				// Load the new configuration from disk
 				err = cli.setupLogger(cfg)
 				if err != nil {
@@ -237,6 +260,34 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 	flags.SetOutput(ioutil.Discard)
 	flags.Usage = func() {}
 
+	flags.Var((funcVar)(func(s string) error {
+		c.Audit.Path = config.String(s)
+		return nil
+	}), "audit-file", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Cache.Path = config.String(s)
+		return nil
+	}), "cache", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		if !validChangeStrategy(s) {
+			return fmt.Errorf("invalid change strategy %q, must be one of %s",
+				s, strings.Join(ChangeStrategies, ", "))
+		}
+		c.ChangeStrategy = config.String(s)
+		return nil
+	}), "change-strategy", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		if !validConflictPolicy(s) {
+			return fmt.Errorf("invalid conflict policy %q, must be one of %s",
+				s, strings.Join(ConflictPolicies, ", "))
+		}
+		c.ConflictPolicy = config.String(s)
+		return nil
+	}), "conflict-policy", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		configPaths = append(configPaths, s)
 		return nil
@@ -341,6 +392,11 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "consul-transport-tls-handshake-timeout", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		c.EnvFile.Path = config.String(s)
+		return nil
+	}), "env-file", "")
+
 	flags.Var((funcVar)(func(s string) error {
 		c.Exec.Enabled = config.Bool(true)
 		c.Exec.Command = []string{s}
@@ -361,6 +417,15 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "exec-kill-timeout", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		sig, err := signals.Parse(s)
+		if err != nil {
+			return err
+		}
+		c.Exec.ReloadSignal = config.Signal(sig)
+		return nil
+	}), "exec-reload-signal", "")
+
 	flags.Var((funcDurationVar)(func(d time.Duration) error {
 		c.Exec.Splay = config.TimeDuration(d)
 		return nil
@@ -380,6 +445,16 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "log-level", "")
 
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.LogUnredacted = config.Bool(b)
+		return nil
+	}), "log-unredacted", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		c.MaskOutput = config.Bool(b)
+		return nil
+	}), "mask-output", "")
+
 	flags.Var((funcDurationVar)(func(d time.Duration) error {
 		c.MaxStale = config.TimeDuration(d)
 		return nil
@@ -407,6 +482,20 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "prefix", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		if !validPrintFormat(s) {
+			return fmt.Errorf("unknown print format %q, must be one of %s",
+				s, strings.Join(PrintFormats, ", "))
+		}
+		c.Print.Format = config.String(s)
+		return nil
+	}), "print", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		c.Print.Path = config.String(s)
+		return nil
+	}), "print-file", "")
+
 	flags.Var((funcBoolVar)(func(b bool) error {
 		c.Pristine = config.Bool(b)
 		return nil
@@ -421,6 +510,11 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "reload-signal", "")
 
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.Rollout.GracePeriod = config.TimeDuration(d)
+		return nil
+	}), "rollout-grace-period", "")
+
 	flags.Var((funcBoolVar)(func(b bool) error {
 		c.Sanitize = config.Bool(b)
 		return nil
@@ -489,6 +583,64 @@ func (cli *CLI) ParseFlags(args []string) (*Config, []string, bool, bool, error)
 		return nil
 	}), "service-format-port", "")
 
+	flags.Var((funcVar)(func(s string) error {
+		serviceConfig := c.Services.LastSeviceConfig()
+		if serviceConfig == nil {
+			return fmt.Errorf("select must be specified after query")
+		}
+		if !validServiceSelect(s) {
+			return fmt.Errorf("invalid service select %q, must be one of %s",
+				s, strings.Join(ServiceSelects, ", "))
+		}
+		serviceConfig.Select = config.String(s)
+		return nil
+	}), "service-select", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		serviceConfig := c.Services.LastSeviceConfig()
+		if serviceConfig == nil {
+			return fmt.Errorf("indexed must be specified after query")
+		}
+		serviceConfig.Indexed = config.Bool(b)
+		return nil
+	}), "service-indexed", "")
+
+	flags.Var((funcBoolVar)(func(b bool) error {
+		serviceConfig := c.Services.LastSeviceConfig()
+		if serviceConfig == nil {
+			return fmt.Errorf("aggregate must be specified after query")
+		}
+		serviceConfig.Aggregate = config.Bool(b)
+		return nil
+	}), "service-aggregate", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		serviceConfig := c.Services.LastSeviceConfig()
+		if serviceConfig == nil {
+			return fmt.Errorf("health must be specified after query")
+		}
+		if !validServiceHealth(s) {
+			return fmt.Errorf("invalid service health %q, must be %q or %q",
+				s, ServiceHealthPassing, ServiceHealthWarning)
+		}
+		serviceConfig.Health = config.String(s)
+		return nil
+	}), "service-health", "")
+
+	flags.Var((funcVar)(func(s string) error {
+		if !validSupervisePolicy(s) {
+			return fmt.Errorf("invalid supervise policy %q, must be one of %s",
+				s, strings.Join(SupervisePolicies, ", "))
+		}
+		c.Supervise.Policy = config.String(s)
+		return nil
+	}), "supervise", "")
+
+	flags.Var((funcDurationVar)(func(d time.Duration) error {
+		c.StartupTimeout = config.TimeDuration(d)
+		return nil
+	}), "startup-timeout", "")
+
 	flags.Var((funcBoolVar)(func(b bool) error {
 		c.Syslog.Enabled = config.Bool(b)
 		return nil
@@ -759,6 +911,91 @@ func loadConfigs(paths []string, o *Config) (*Config, error) {
 	return finalC, nil
 }
 
+// loadConfig parses the flags and configuration files of a subcommand into a
+// finalized and validated configuration, and sets up logging. On error, it
+// returns the exit status to use.
+func (cli *CLI) loadConfig(args []string) (*Config, int, error) {
+	cfg, paths, _, _, err := cli.ParseFlags(args)
+	if err != nil {
+		return nil, ExitCodeParseFlagsError, err
+	}
+
+	cfg, err = loadConfigs(paths, cfg.Copy())
+	if err != nil {
+		return nil, ExitCodeConfigError, err
+	}
+	cfg.Finalize()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, ExitCodeConfigError, err
+	}
+
+	if err := cli.setupLogger(cfg); err != nil {
+		return nil, ExitCodeConfigError, err
+	}
+	return cfg, ExitCodeOK, nil
+}
+
+// runValidate checks the configuration without connecting to Consul or Vault,
+// and reports every problem found.
+func (cli *CLI) runValidate(args []string) int {
+	cfg, code, err := cli.loadConfig(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			fmt.Fprintf(cli.outStream, usage, version.Name)
+			return ExitCodeOK
+		}
+		fmt.Fprintln(cli.errStream, err.Error())
+		return code
+	}
+
+	for _, w := range cfg.Warnings() {
+		fmt.Fprintln(cli.errStream, "warning:", w)
+	}
+	fmt.Fprintln(cli.outStream, "The configuration is valid.")
+	return ExitCodeOK
+}
+
+// runExplain fetches every dependency once and writes where each variable set
+// by envconsul came from, instead of running a child process.
+func (cli *CLI) runExplain(args []string) int {
+	cfg, code, err := cli.loadConfig(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			fmt.Fprintf(cli.outStream, usage, version.Name)
+			return ExitCodeOK
+		}
+		fmt.Fprintln(cli.errStream, err.Error())
+		return code
+	}
+
+	runner, err := NewRunner(cfg, true)
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+	defer runner.Stop()
+
+	timeout := config.TimeDurationVal(cfg.StartupTimeout)
+	if timeout == 0 {
+		timeout = explainTimeout
+	}
+	if err := runner.fetch(timeout); err != nil {
+		if typed, ok := err.(*ErrStartupTimeout); ok {
+			return logError(err, typed.ExitStatus())
+		}
+		return logError(err, ExitCodeRunnerError)
+	}
+
+	vars, err := runner.explain()
+	if err != nil {
+		return logError(err, ExitCodeRunnerError)
+	}
+	if err := runner.writeExplanation(cli.outStream, vars); err != nil {
+		return logError(err, ExitCodeError)
+	}
+	return ExitCodeOK
+}
+
 // logError logs an error message and then returns the given status.
 func logError(err error, status int) int {
 	hclog.Default().Error(err.Error())
@@ -816,19 +1053,53 @@ func valueFrom[T any](p *T) T {
 }
 
 const usage = `Usage: %s [options] <command>
+       %[1]s explain [options]
+       %[1]s validate [options]
 
   Watches values from Consul's K/V store and Vault secrets to set environment
   variables when the values are changed. It spawns a child process populated
   with the environment variables.
 
+  The explain command fetches every prefix, secret and service once and,
+  instead of running a command, prints each variable with the source and key
+  it came from, the transformations applied to the key and the values it
+  overrode from other sources and the parent environment.
+
+  The validate command checks the configuration and exits with a non-zero
+  status if it finds problems, such as templates which do not compile, paths
+  and queries which do not parse, or settings which contradict each other.
+
 Options:
 
+  -audit-file=<path>
+      Append an event listing the keys which were added, removed and changed,
+      with redacted values, as a line of JSON to the given file every time the
+      environment changes. Events are always logged at the info level
+
+  -cache=<path>
+      Cache the last data of every prefix, secret and service in the given
+      file, and start from it when Consul or Vault do not return data in time.
+      Secrets are only cached when they can be encrypted with the key in the
+      ENVCONSUL_CACHE_KEY environment variable
+
+  -change-strategy=<strategy>
+      What to do with the child process when the environment changes:
+      "restart" (default) starts a new child, "signal" writes the env file and
+      sends the exec reload signal to the running child, and "exit" stops the
+      child and exits, so an outer orchestrator can reschedule it
+
   -config=<path>
       Sets the path to a configuration file or folder on disk. This can be
       specified multiple times to load multiple files or folders. If multiple
       values are given, they are merged left-to-right, and CLI arguments take
       the top-most precedence.
 
+  -conflict-policy=<policy>
+      What to do when a key is set by more than one source, or is also set in
+      the parent environment: "last-wins" (default) keeps the value from the
+      last source, "first-wins" keeps the first one, "warn" keeps the last one
+      and logs a warning, and "error" stops envconsul
+
   -consul-addr=<address>
       Sets the address of the Consul instance
 
@@ -889,6 +1160,10 @@ Options:
   -consul-transport-tls-handshake-timeout=<duration>
       Sets the handshake timeout
 
+  -env-file=<path>
+      Write the environment to the given file, as dotenv, every time it
+      changes. This is required by the "signal" change strategy
+
   -exec=<command>
       Enable exec mode to run as a supervisor-like process - the given command
       will receive all signals provided to the parent process and will receive a
@@ -900,6 +1175,10 @@ Options:
   -exec-kill-timeout=<duration>
       Amount of time to wait before force-killing the child
 
+  -exec-reload-signal=<signal>
+      Signal to send to the child when the environment changes with the
+      "signal" change strategy
+
   -exec-splay=<duration>
       Amount of time to wait before sending signals
 
@@ -910,6 +1189,15 @@ Options:
       Set the logging level - values are "trace", "debug", "info", "warn", 
       and "error"
 
+  -log-unredacted
+      Log the values of secrets and of keys matching the redact keys, which
+      are otherwise redacted. This is meant for local debugging only, and
+      audit events are still redacted
+
+  -mask-output
+      Replace the values of secrets with "****" in the standard out and
+      standard error of the child process
+
   -max-stale=<duration>
       Set the maximum staleness and allow stale queries to Consul which will
       distribute work among all servers instead of just the leader
@@ -929,6 +1217,15 @@ Options:
       precedence, including any values specified with -secret (secrets
       overrides prefixes)
 
+  -print=<format>
+      Print the environment instead of running a command. Supported formats
+      are "dotenv", "json", "export" and "systemd". The parent environment is
+      not included. Combine with -once to print a single time and exit
+
+  -print-file=<path>
+      Write the printed environment to this file instead of standard out. The
+      file is replaced atomically every time the environment changes
+
   -pristine
       Only use values retrieved from prefixes and secrets, do not inherit the
       existing environment variables
@@ -936,6 +1233,12 @@ Options:
   -reload-signal=<signal>
       Signal to listen to reload configuration
 
+  -rollout-grace-period=<duration>
+      When the environment changes, start the new child process alongside the
+      current one, and only stop the current one once the new one has been
+      running for this long. If the new child exits before, the current one
+      keeps running with the last known good environment
+
   -sanitize
       Replace invalid characters in keys to underscores
 
@@ -963,6 +1266,34 @@ Options:
   -service-format-port=<{{service}}/{{key}}>
       Format key environment for service port.
 
+  -service-select=<strategy>
+      Instance used for the single value service variables when there is more
+      than one: "last" (default), "first", "random" or "node" (sorted by node
+      name)
+
+  -service-indexed
+      Add the variables of every service instance, as {{service}}/<n>/{{key}},
+      and the number of instances as {{service}}/count
+
+  -service-aggregate
+      Add a comma separated list of the host:port of every service instance as
+      {{service}}/addresses, and the number of instances as {{service}}/count
+
+  -service-health=<passing|warning>
+      Query the health endpoint instead of the catalog, returning instances
+      that are passing, or passing and warning
+
+  -startup-timeout=<duration>
+      Exit with an error listing the prefixes, secrets and services which have
+      not returned data when this much time has passed since start. The
+      default is to wait forever
+
+  -supervise=<policy>
+      Restart the child process when it exits: "never" (default), "on-failure"
+      or "always". Restarts back off exponentially, and envconsul gives up and
+      exits with the child's exit code after too many restarts. The backoff and
+      limits can be changed using a configuration file
+
   -syslog
       Send the output to syslog instead of standard error and standard out. The
       syslog facility defaults to LOCAL0 and can be changed using a
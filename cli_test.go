@@ -465,6 +465,67 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"exec-reload-signal",
+			[]string{"-exec-reload-signal", "SIGUSR1"},
+			&Config{
+				Exec: &config.ExecConfig{
+					ReloadSignal: config.Signal(syscall.SIGUSR1),
+				},
+			},
+			false,
+		},
+		{
+			"audit-file",
+			[]string{"-audit-file", "/var/log/envconsul/audit.jsonl"},
+			&Config{
+				Audit: &AuditConfig{
+					Path: config.String("/var/log/envconsul/audit.jsonl"),
+				},
+			},
+			false,
+		},
+		{
+			"cache",
+			[]string{"-cache", "/var/cache/envconsul.json"},
+			&Config{
+				Cache: &CacheConfig{
+					Path: config.String("/var/cache/envconsul.json"),
+				},
+			},
+			false,
+		},
+		{
+			"change-strategy",
+			[]string{"-change-strategy", "signal", "-env-file", "/run/app.env"},
+			&Config{
+				ChangeStrategy: config.String("signal"),
+				EnvFile: &EnvFileConfig{
+					Path: config.String("/run/app.env"),
+				},
+			},
+			false,
+		},
+		{
+			"change-strategy-invalid",
+			[]string{"-change-strategy", "reboot"},
+			nil,
+			true,
+		},
+		{
+			"conflict-policy",
+			[]string{"-conflict-policy", "first-wins"},
+			&Config{
+				ConflictPolicy: config.String("first-wins"),
+			},
+			false,
+		},
+		{
+			"conflict-policy-invalid",
+			[]string{"-conflict-policy", "newest"},
+			nil,
+			true,
+		},
 		{
 			"exec-splay",
 			[]string{"-exec-splay", "10s"},
@@ -491,6 +552,22 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"log-unredacted",
+			[]string{"-log-unredacted"},
+			&Config{
+				LogUnredacted: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"mask-output",
+			[]string{"-mask-output"},
+			&Config{
+				MaskOutput: config.Bool(true),
+			},
+			false,
+		},
 		{
 			"max-stale",
 			[]string{"-max-stale", "10s"},
@@ -507,6 +584,23 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"print",
+			[]string{"-print", "dotenv", "-print-file", "/etc/app.env"},
+			&Config{
+				Print: &PrintConfig{
+					Format: config.String("dotenv"),
+					Path:   config.String("/etc/app.env"),
+				},
+			},
+			false,
+		},
+		{
+			"print_invalid",
+			[]string{"-print", "yaml"},
+			nil,
+			true,
+		},
 		{
 			"prefix",
 			[]string{"-prefix", "foo/bar"},
@@ -605,6 +699,16 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"rollout-grace-period",
+			[]string{"-rollout-grace-period", "10s"},
+			&Config{
+				Rollout: &RolloutConfig{
+					GracePeriod: config.TimeDuration(10 * time.Second),
+				},
+			},
+			false,
+		},
 		{
 			"sanitize",
 			[]string{"-sanitize"},
@@ -703,6 +807,46 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"service_instances",
+			[]string{
+				"-service-query", "service",
+				"-service-select", "node",
+				"-service-indexed",
+				"-service-aggregate",
+				"-service-health", "warning",
+			},
+			&Config{
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:     config.String("service"),
+						Select:    config.String("node"),
+						Indexed:   config.Bool(true),
+						Aggregate: config.Bool(true),
+						Health:    config.String("warning"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"service_health_invalid",
+			[]string{
+				"-service-query", "service",
+				"-service-health", "critical",
+			},
+			nil,
+			true,
+		},
+		{
+			"service_select_invalid",
+			[]string{
+				"-service-query", "service",
+				"-service-select", "nope",
+			},
+			nil,
+			true,
+		},
 		{
 			"service_format_multy",
 			[]string{
@@ -741,6 +885,30 @@ func TestCLI_ParseFlags(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"startup-timeout",
+			[]string{"-startup-timeout", "30s"},
+			&Config{
+				StartupTimeout: config.TimeDuration(30 * time.Second),
+			},
+			false,
+		},
+		{
+			"supervise",
+			[]string{"-supervise", "on-failure"},
+			&Config{
+				Supervise: &SuperviseConfig{
+					Policy: config.String("on-failure"),
+				},
+			},
+			false,
+		},
+		{
+			"supervise_invalid",
+			[]string{"-supervise", "sometimes"},
+			nil,
+			true,
+		},
 		{
 			"syslog",
 			[]string{"-syslog"},
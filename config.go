@@ -33,6 +33,10 @@ const (
 
 	// DefaultKillSignal is the default signal for termination.
 	DefaultKillSignal = syscall.SIGINT
+
+	// DefaultOptionalTimeout is the default time to wait for optional sources
+	// to return data.
+	DefaultOptionalTimeout = 5 * time.Second
 )
 
 // Config is used to configure Consul ENV
@@ -40,19 +44,56 @@ type Config struct {
 	// Consul is the configuration for connecting to a Consul cluster.
 	Consul *config.ConsulConfig `mapstructure:"consul"`
 
+	// Audit is the configuration for the audit events written every time the
+	// environment changes.
+	Audit *AuditConfig `mapstructure:"audit"`
+
+	// Cache is the configuration for the on-disk cache of the last data returned
+	// by each dependency.
+	Cache *CacheConfig `mapstructure:"cache"`
+
+	// ChangeStrategy is what to do with the child process when the environment
+	// changes. It can be overridden by each prefix, secret and service.
+	ChangeStrategy *string `mapstructure:"change_strategy"`
+
+	// ConflictPolicy is what to do when a key is set by more than one source, or
+	// is also set in the parent environment.
+	ConflictPolicy *string `mapstructure:"conflict_policy"`
+
+	// EnvFile is the configuration for writing the environment to a file every
+	// time it changes.
+	EnvFile *EnvFileConfig `mapstructure:"env_file"`
+
 	// Exec is the configuration for exec/supervise mode.
 	Exec *config.ExecConfig `mapstructure:"exec"`
 
+	// IgnoreChanges is a list of globs of keys whose changes do not restart,
+	// signal or stop the child process. The outputs, like the env file, are
+	// still updated.
+	IgnoreChanges []string `mapstructure:"ignore_changes"`
+
 	// KillSignal is the signal to listen for a graceful terminate event.
 	KillSignal *os.Signal `mapstructure:"kill_signal"`
 
 	// LogLevel is the level with which to log for this config.
 	LogLevel *string `mapstructure:"log_level"`
 
+	// LogUnredacted disables redacting the values of secrets and sensitive keys
+	// in log output. Audit events are always redacted.
+	LogUnredacted *bool `mapstructure:"log_unredacted"`
+
+	// MaskOutput replaces the values of secrets in the output of the child
+	// process.
+	MaskOutput *bool `mapstructure:"mask_output"`
+
 	// MaxStale is the maximum amount of time for staleness from Consul as given
 	// by LastContact.
 	MaxStale *time.Duration `mapstructure:"max_stale"`
 
+	// OptionalTimeout is how long to wait for an optional prefix, secret or
+	// service to return data before it counts as empty.
+	OptionalTimeout *time.Duration `mapstructure:"optional_timeout"`
+
 	// PidFile is the path on disk where a PID file should be written containing
 	// this processes PID.
 	PidFile *string `mapstructure:"pid_file"`
@@ -61,14 +102,40 @@ type Config struct {
 	// in merge order.
 	Prefixes *PrefixConfigs `mapstructure:"prefix"`
 
+	// Print is the configuration for printing the environment instead of
+	// running a child process.
+	Print *PrintConfig `mapstructure:"print"`
+
 	// Pristine indicates that we want a clean environment only
 	// composed of consul config variables, not inheriting from exising
 	// environment
 	Pristine *bool `mapstructure:"pristine"`
 
+	// Readiness is the configuration for probing whether the child process is
+	// ready. It is set as a readiness stanza inside exec, since Exec is decoded
+	// into the exec configuration of consul-template.
+	Readiness *ReadinessConfig `mapstructure:"readiness"`
+
+	// Redact is the configuration for hiding the values of secrets and sensitive
+	// keys in log output.
+	Redact *RedactConfig `mapstructure:"redact"`
+
 	// ReloadSignal is the signal to listen for a reload event.
 	ReloadSignal *os.Signal `mapstructure:"reload_signal"`
 
+	// Require is the list of variables which must be set, and optionally match
+	// a check, in the environment of the child process before it is started.
+	Require *RequireConfigs `mapstructure:"require"`
+
+	// RestartOn is a list of globs of keys. When set, only changes to matching
+	// keys restart, signal or stop the child process.
+	RestartOn []string `mapstructure:"restart_on"`
+
+	// Rollout is the configuration for starting the new child process
+	// alongside the current one when the environment changes, and keeping the
+	// current one if the new one fails.
+	Rollout *RolloutConfig `mapstructure:"rollout"`
+
 	// Sanitize converts any "bad" characters in key values to underscores
 	Sanitize *bool `mapstructure:"sanitize"`
 
@@ -77,6 +144,14 @@ type Config struct {
 
 	Services *ServiceConfigs `mapstructure:"service"`
 
+	// StartupTimeout is how long to wait for every dependency to return data
+	// before giving up. Zero waits forever.
+	StartupTimeout *time.Duration `mapstructure:"startup_timeout"`
+
+	// Supervise is the configuration for restarting the child process when it
+	// exits on its own.
+	Supervise *SuperviseConfig `mapstructure:"supervise"`
+
 	// Syslog is the configuration for syslog.
 	Syslog *config.SyslogConfig `mapstructure:"syslog"`
 
@@ -99,34 +174,90 @@ func (c *Config) Copy() *Config {
 		o.Consul = c.Consul.Copy()
 	}
 
+	if c.Audit != nil {
+		o.Audit = c.Audit.Copy()
+	}
+
+	if c.Cache != nil {
+		o.Cache = c.Cache.Copy()
+	}
+
+	o.ChangeStrategy = c.ChangeStrategy
+
+	o.ConflictPolicy = c.ConflictPolicy
+
+	if c.EnvFile != nil {
+		o.EnvFile = c.EnvFile.Copy()
+	}
+
 	if c.Exec != nil {
 		o.Exec = c.Exec.Copy()
 	}
 
+	if c.IgnoreChanges != nil {
+		o.IgnoreChanges = append([]string{}, c.IgnoreChanges...)
+	}
+
 	o.KillSignal = c.KillSignal
 
 	o.LogLevel = c.LogLevel
 
+	o.LogUnredacted = c.LogUnredacted
+
+	o.MaskOutput = c.MaskOutput
+
 	o.MaxStale = c.MaxStale
 
-	o.PidFile = c.PidFile
+	o.OptionalTimeout = c.OptionalTimeout
 
-	o.ReloadSignal = c.ReloadSignal
+	o.PidFile = c.PidFile
 
 	if c.Prefixes != nil {
 		o.Prefixes = c.Prefixes.Copy()
 	}
 
-	o.Services = c.Services
+	if c.Print != nil {
+		o.Print = c.Print.Copy()
+	}
 
 	o.Pristine = c.Pristine
 
+	if c.Readiness != nil {
+		o.Readiness = c.Readiness.Copy()
+	}
+
+	if c.Redact != nil {
+		o.Redact = c.Redact.Copy()
+	}
+
+	o.ReloadSignal = c.ReloadSignal
+
+	if c.Require != nil {
+		o.Require = c.Require.Copy()
+	}
+
+	if c.RestartOn != nil {
+		o.RestartOn = append([]string{}, c.RestartOn...)
+	}
+
+	if c.Rollout != nil {
+		o.Rollout = c.Rollout.Copy()
+	}
+
 	o.Sanitize = c.Sanitize
 
 	if c.Secrets != nil {
 		o.Secrets = c.Secrets.Copy()
 	}
 
+	o.Services = c.Services
+
+	o.StartupTimeout = c.StartupTimeout
+
+	if c.Supervise != nil {
+		o.Supervise = c.Supervise.Copy()
+	}
+
 	if c.Syslog != nil {
 		o.Syslog = c.Syslog.Copy()
 	}
@@ -162,10 +293,34 @@ func (c *Config) Merge(o *Config) *Config {
 		r.Consul = r.Consul.Merge(o.Consul)
 	}
 
+	if o.Audit != nil {
+		r.Audit = r.Audit.Merge(o.Audit)
+	}
+
+	if o.Cache != nil {
+		r.Cache = r.Cache.Merge(o.Cache)
+	}
+
+	if o.ChangeStrategy != nil {
+		r.ChangeStrategy = o.ChangeStrategy
+	}
+
+	if o.ConflictPolicy != nil {
+		r.ConflictPolicy = o.ConflictPolicy
+	}
+
+	if o.EnvFile != nil {
+		r.EnvFile = r.EnvFile.Merge(o.EnvFile)
+	}
+
 	if o.Exec != nil {
 		r.Exec = r.Exec.Merge(o.Exec)
 	}
 
+	if o.IgnoreChanges != nil {
+		r.IgnoreChanges = append([]string{}, o.IgnoreChanges...)
+	}
+
 	if o.KillSignal != nil {
 		r.KillSignal = o.KillSignal
 	}
@@ -174,30 +329,62 @@ func (c *Config) Merge(o *Config) *Config {
 		r.LogLevel = o.LogLevel
 	}
 
+	if o.LogUnredacted != nil {
+		r.LogUnredacted = o.LogUnredacted
+	}
+
+	if o.MaskOutput != nil {
+		r.MaskOutput = o.MaskOutput
+	}
+
 	if o.MaxStale != nil {
 		r.MaxStale = o.MaxStale
 	}
 
-	if o.PidFile != nil {
-		r.PidFile = o.PidFile
+	if o.OptionalTimeout != nil {
+		r.OptionalTimeout = o.OptionalTimeout
 	}
 
-	if o.ReloadSignal != nil {
-		r.ReloadSignal = o.ReloadSignal
+	if o.PidFile != nil {
+		r.PidFile = o.PidFile
 	}
 
 	if o.Prefixes != nil {
 		r.Prefixes = r.Prefixes.Merge(o.Prefixes)
 	}
 
-	if o.Services != nil {
-		r.Services = r.Services.Merge(o.Services)
+	if o.Print != nil {
+		r.Print = r.Print.Merge(o.Print)
 	}
 
 	if o.Pristine != nil {
 		r.Pristine = o.Pristine
 	}
 
+	if o.Readiness != nil {
+		r.Readiness = r.Readiness.Merge(o.Readiness)
+	}
+
+	if o.Redact != nil {
+		r.Redact = r.Redact.Merge(o.Redact)
+	}
+
+	if o.ReloadSignal != nil {
+		r.ReloadSignal = o.ReloadSignal
+	}
+
+	if o.Require != nil {
+		r.Require = r.Require.Merge(o.Require)
+	}
+
+	if o.RestartOn != nil {
+		r.RestartOn = append([]string{}, o.RestartOn...)
+	}
+
+	if o.Rollout != nil {
+		r.Rollout = r.Rollout.Merge(o.Rollout)
+	}
+
 	if o.Sanitize != nil {
 		r.Sanitize = o.Sanitize
 	}
@@ -206,6 +393,18 @@ func (c *Config) Merge(o *Config) *Config {
 		r.Secrets = r.Secrets.Merge(o.Secrets)
 	}
 
+	if o.Services != nil {
+		r.Services = r.Services.Merge(o.Services)
+	}
+
+	if o.StartupTimeout != nil {
+		r.StartupTimeout = o.StartupTimeout
+	}
+
+	if o.Supervise != nil {
+		r.Supervise = r.Supervise.Merge(o.Supervise)
+	}
+
 	if o.Syslog != nil {
 		r.Syslog = r.Syslog.Merge(o.Syslog)
 	}
@@ -240,13 +439,21 @@ func Parse(s string) (*Config, error) {
 	}
 
 	flattenKeys(parsed, []string{
+		"audit",
+		"cache",
 		"consul",
 		"consul.auth",
 		"consul.retry",
 		"consul.ssl",
 		"consul.transport",
+		"env_file",
 		"exec",
 		"exec.env",
+		"exec.readiness",
+		"print",
+		"redact",
+		"rollout",
+		"supervise",
 		"syslog",
 		"vault",
 		"vault.retry",
@@ -255,6 +462,28 @@ func Parse(s string) (*Config, error) {
 		"wait",
 	})
 
+	// The readiness stanza is set inside exec, but exec is decoded into the
+	// exec configuration of consul-template, which does not know about it, so
+	// it is moved to the top-level before decoding.
+	if _, ok := parsed["readiness"]; ok {
+		return nil, errors.New("readiness is a child stanza of exec instead " +
+			"of a top-level stanza")
+	}
+	if exec, ok := parsed["exec"].(map[string]interface{}); ok {
+		if readiness, ok := exec["readiness"]; ok {
+			parsed["readiness"] = readiness
+			delete(exec, "readiness")
+		}
+	}
+
+	// Flatten the single stanzas nested in each secret. These are inside a
+	// list, so they are not reachable by the flattenKeys call above.
+	if secrets, ok := parsed["secret"].([]map[string]interface{}); ok {
+		for _, s := range secrets {
+			flattenKeys(s, []string{"files"})
+		}
+	}
+
 	// Deprecations
 	// TODO remove in 0.8.0
 	flattenKeys(parsed, []string{
@@ -499,34 +728,68 @@ func (c *Config) GoString() string {
 
 	return fmt.Sprintf("&Config{"+
 		"Consul:%s, "+
+		"Audit:%s, "+
+		"Cache:%s, "+
+		"ChangeStrategy:%s, "+
+		"ConflictPolicy:%s, "+
+		"EnvFile:%s, "+
 		"Exec:%s, "+
+		"IgnoreChanges:%q, "+
 		"KillSignal:%s, "+
 		"LogLevel:%s, "+
+		"LogUnredacted:%s, "+
+		"MaskOutput:%s, "+
 		"MaxStale:%s, "+
+		"OptionalTimeout:%s, "+
 		"PidFile:%s, "+
 		"Prefixes:%s, "+
+		"Print:%s, "+
 		"Pristine:%s, "+
+		"Readiness:%s, "+
+		"Redact:%s, "+
 		"ReloadSignal:%s, "+
+		"Require:%s, "+
+		"RestartOn:%q, "+
+		"Rollout:%s, "+
 		"Sanitize:%s, "+
 		"Secrets:%s, "+
 		"Services:%s, "+
+		"StartupTimeout:%s, "+
+		"Supervise:%s, "+
 		"Syslog:%s, "+
 		"Upcase:%s, "+
 		"Vault:%s, "+
 		"Wait:%s"+
 		"}",
 		c.Consul.GoString(),
+		c.Audit.GoString(),
+		c.Cache.GoString(),
+		config.StringGoString(c.ChangeStrategy),
+		config.StringGoString(c.ConflictPolicy),
+		c.EnvFile.GoString(),
 		c.Exec.GoString(),
+		c.IgnoreChanges,
 		config.SignalGoString(c.KillSignal),
 		config.StringGoString(c.LogLevel),
+		config.BoolGoString(c.LogUnredacted),
+		config.BoolGoString(c.MaskOutput),
 		config.TimeDurationGoString(c.MaxStale),
+		config.TimeDurationGoString(c.OptionalTimeout),
 		config.StringGoString(c.PidFile),
 		c.Prefixes.GoString(),
+		c.Print.GoString(),
 		config.BoolGoString(c.Pristine),
+		c.Readiness.GoString(),
+		c.Redact.GoString(),
 		config.SignalGoString(c.ReloadSignal),
+		c.Require.GoString(),
+		c.RestartOn,
+		c.Rollout.GoString(),
 		config.BoolGoString(c.Sanitize),
 		c.Secrets.GoString(),
 		c.Services.GoString(),
+		config.TimeDurationGoString(c.StartupTimeout),
+		c.Supervise.GoString(),
 		c.Syslog.GoString(),
 		config.BoolGoString(c.Upcase),
 		c.Vault.GoString(),
@@ -538,14 +801,23 @@ func (c *Config) GoString() string {
 // variables may be set which control the values for the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		Consul:   config.DefaultConsulConfig(),
-		Exec:     config.DefaultExecConfig(),
-		Prefixes: DefaultPrefixConfigs(),
-		Secrets:  DefaultPrefixConfigs(),
-		Services: DefaultServiceConfigs(),
-		Syslog:   config.DefaultSyslogConfig(),
-		Vault:    config.DefaultVaultConfig(),
-		Wait:     config.DefaultWaitConfig(),
+		Audit:     DefaultAuditConfig(),
+		Cache:     DefaultCacheConfig(),
+		Consul:    config.DefaultConsulConfig(),
+		EnvFile:   DefaultEnvFileConfig(),
+		Exec:      config.DefaultExecConfig(),
+		Prefixes:  DefaultPrefixConfigs(),
+		Print:     DefaultPrintConfig(),
+		Readiness: DefaultReadinessConfig(),
+		Redact:    DefaultRedactConfig(),
+		Require:   DefaultRequireConfigs(),
+		Rollout:   DefaultRolloutConfig(),
+		Secrets:   DefaultPrefixConfigs(),
+		Services:  DefaultServiceConfigs(),
+		Supervise: DefaultSuperviseConfig(),
+		Syslog:    config.DefaultSyslogConfig(),
+		Vault:     config.DefaultVaultConfig(),
+		Wait:      config.DefaultWaitConfig(),
 	}
 }
 
@@ -560,11 +832,38 @@ func (c *Config) Finalize() {
 	}
 	c.Consul.Finalize()
 
+	if c.Audit == nil {
+		c.Audit = DefaultAuditConfig()
+	}
+	c.Audit.Finalize()
+
+	if c.Cache == nil {
+		c.Cache = DefaultCacheConfig()
+	}
+	c.Cache.Finalize()
+
+	if c.ChangeStrategy == nil {
+		c.ChangeStrategy = config.String(ChangeStrategyRestart)
+	}
+
+	if c.ConflictPolicy == nil {
+		c.ConflictPolicy = config.String(ConflictPolicyLastWins)
+	}
+
+	if c.EnvFile == nil {
+		c.EnvFile = DefaultEnvFileConfig()
+	}
+	c.EnvFile.Finalize()
+
 	if c.Exec == nil {
 		c.Exec = config.DefaultExecConfig()
 	}
 	c.Exec.Finalize()
 
+	if c.IgnoreChanges == nil {
+		c.IgnoreChanges = []string{}
+	}
+
 	if c.KillSignal == nil {
 		c.KillSignal = config.Signal(DefaultKillSignal)
 	}
@@ -576,27 +875,68 @@ func (c *Config) Finalize() {
 		}, DefaultLogLevel)
 	}
 
+	if c.LogUnredacted == nil {
+		c.LogUnredacted = config.Bool(false)
+	}
+
+	if c.MaskOutput == nil {
+		c.MaskOutput = config.Bool(false)
+	}
+
 	if c.MaxStale == nil {
 		c.MaxStale = config.TimeDuration(DefaultMaxStale)
 	}
 
+	if c.OptionalTimeout == nil {
+		c.OptionalTimeout = config.TimeDuration(DefaultOptionalTimeout)
+	}
+
+	if c.PidFile == nil {
+		c.PidFile = config.String("")
+	}
+
 	if c.Prefixes == nil {
 		c.Prefixes = DefaultPrefixConfigs()
 	}
 	c.Prefixes.Finalize()
 
-	if c.PidFile == nil {
-		c.PidFile = config.String("")
+	if c.Print == nil {
+		c.Print = DefaultPrintConfig()
 	}
+	c.Print.Finalize()
 
 	if c.Pristine == nil {
 		c.Pristine = config.Bool(false)
 	}
 
+	if c.Readiness == nil {
+		c.Readiness = DefaultReadinessConfig()
+	}
+	c.Readiness.Finalize()
+
+	if c.Redact == nil {
+		c.Redact = DefaultRedactConfig()
+	}
+	c.Redact.Finalize()
+
 	if c.ReloadSignal == nil {
 		c.ReloadSignal = config.Signal(DefaultReloadSignal)
 	}
 
+	if c.Require == nil {
+		c.Require = DefaultRequireConfigs()
+	}
+	c.Require.Finalize()
+
+	if c.RestartOn == nil {
+		c.RestartOn = []string{}
+	}
+
+	if c.Rollout == nil {
+		c.Rollout = DefaultRolloutConfig()
+	}
+	c.Rollout.Finalize()
+
 	if c.Sanitize == nil {
 		c.Sanitize = config.Bool(false)
 	}
@@ -611,6 +951,15 @@ func (c *Config) Finalize() {
 	}
 	c.Services.Finalize()
 
+	if c.StartupTimeout == nil {
+		c.StartupTimeout = config.TimeDuration(0)
+	}
+
+	if c.Supervise == nil {
+		c.Supervise = DefaultSuperviseConfig()
+	}
+	c.Supervise.Finalize()
+
 	if c.Syslog == nil {
 		c.Syslog = config.DefaultSyslogConfig()
 	}
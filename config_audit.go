@@ -0,0 +1,99 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// DefaultAuditPerms are the permissions of the audit file when none are
+// configured.
+const DefaultAuditPerms os.FileMode = 0o600
+
+// AuditConfig is the configuration for writing an audit event, listing the
+// keys which were added, removed and changed, every time the environment
+// changes. Events are always logged, and also appended to a file when a path
+// is given.
+type AuditConfig struct {
+	// Path is the file the events are appended to, one JSON object per line.
+	Path *string `mapstructure:"path"`
+
+	// Perms are the permissions of the file when it is created.
+	Perms *os.FileMode `mapstructure:"perms"`
+}
+
+func DefaultAuditConfig() *AuditConfig {
+	return &AuditConfig{}
+}
+
+func (c *AuditConfig) Copy() *AuditConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o AuditConfig
+
+	o.Path = c.Path
+
+	o.Perms = c.Perms
+
+	return &o
+}
+
+func (c *AuditConfig) Merge(o *AuditConfig) *AuditConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Path != nil {
+		r.Path = o.Path
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	return r
+}
+
+func (c *AuditConfig) Finalize() {
+	if c.Path == nil {
+		c.Path = config.String("")
+	}
+
+	if c.Perms == nil {
+		c.Perms = config.FileMode(DefaultAuditPerms)
+	}
+}
+
+// Enabled returns true if events should be appended to a file.
+func (c *AuditConfig) Enabled() bool {
+	return c != nil && config.StringPresent(c.Path)
+}
+
+func (c *AuditConfig) GoString() string {
+	if c == nil {
+		return "(*AuditConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&AuditConfig{"+
+		"Path:%s, "+
+		"Perms:%s"+
+		"}",
+		config.StringGoString(c.Path),
+		config.FileModeGoString(c.Perms),
+	)
+}
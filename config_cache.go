@@ -0,0 +1,155 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultCacheDeadline is how long to wait for live data before starting
+	// from the cache when none is configured.
+	DefaultCacheDeadline = 10 * time.Second
+
+	// DefaultCacheKeyEnv is the name of the environment variable which holds
+	// the cache encryption key when no key file is configured.
+	DefaultCacheKeyEnv = "ENVCONSUL_CACHE_KEY"
+
+	// DefaultCachePerms are the permissions of the cache file when none are
+	// configured.
+	DefaultCachePerms os.FileMode = 0o600
+)
+
+// CacheConfig is the configuration for the on-disk cache of the last data
+// returned by each prefix, secret and service, which is used to start the
+// child process when Consul or Vault are unavailable.
+type CacheConfig struct {
+	// Path is the cache file. The cache is disabled when this is empty.
+	Path *string `mapstructure:"path"`
+
+	// Deadline is how long to wait for live data before starting from the
+	// cache.
+	Deadline *time.Duration `mapstructure:"deadline"`
+
+	// KeyFile is a file holding the key used to encrypt the data of secrets.
+	// When empty, the key is read from the environment variable KeyEnv.
+	// Secrets are not cached when there is no key.
+	KeyFile *string `mapstructure:"key_file"`
+	KeyEnv  *string `mapstructure:"key_env"`
+
+	// Perms are the permissions of the cache file.
+	Perms *os.FileMode `mapstructure:"perms"`
+}
+
+func DefaultCacheConfig() *CacheConfig {
+	return &CacheConfig{}
+}
+
+func (c *CacheConfig) Copy() *CacheConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o CacheConfig
+
+	o.Path = c.Path
+
+	o.Deadline = c.Deadline
+
+	o.KeyFile = c.KeyFile
+
+	o.KeyEnv = c.KeyEnv
+
+	o.Perms = c.Perms
+
+	return &o
+}
+
+func (c *CacheConfig) Merge(o *CacheConfig) *CacheConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Path != nil {
+		r.Path = o.Path
+	}
+
+	if o.Deadline != nil {
+		r.Deadline = o.Deadline
+	}
+
+	if o.KeyFile != nil {
+		r.KeyFile = o.KeyFile
+	}
+
+	if o.KeyEnv != nil {
+		r.KeyEnv = o.KeyEnv
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	return r
+}
+
+func (c *CacheConfig) Finalize() {
+	if c.Path == nil {
+		c.Path = config.String("")
+	}
+
+	if c.Deadline == nil {
+		c.Deadline = config.TimeDuration(DefaultCacheDeadline)
+	}
+
+	if c.KeyFile == nil {
+		c.KeyFile = config.String("")
+	}
+
+	if c.KeyEnv == nil {
+		c.KeyEnv = config.String(DefaultCacheKeyEnv)
+	}
+
+	if c.Perms == nil {
+		c.Perms = config.FileMode(DefaultCachePerms)
+	}
+}
+
+// Enabled returns true if the cache should be used.
+func (c *CacheConfig) Enabled() bool {
+	return c != nil && config.StringPresent(c.Path)
+}
+
+func (c *CacheConfig) GoString() string {
+	if c == nil {
+		return "(*CacheConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&CacheConfig{"+
+		"Path:%s, "+
+		"Deadline:%s, "+
+		"KeyFile:%s, "+
+		"KeyEnv:%s, "+
+		"Perms:%s"+
+		"}",
+		config.StringGoString(c.Path),
+		config.TimeDurationGoString(c.Deadline),
+		config.StringGoString(c.KeyFile),
+		config.StringGoString(c.KeyEnv),
+		config.FileModeGoString(c.Perms),
+	)
+}
@@ -0,0 +1,121 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultEnvFileFormat is the format of the env file when none is
+	// configured.
+	DefaultEnvFileFormat = PrintFormatDotenv
+
+	// DefaultEnvFilePerms are the permissions of the env file when none are
+	// configured.
+	DefaultEnvFilePerms os.FileMode = 0o600
+)
+
+// EnvFileConfig is the configuration for writing the environment to a file
+// every time it changes, alongside running the child process. The child can
+// read the file to pick up changes without being restarted.
+type EnvFileConfig struct {
+	// Path is the file to write the environment to. Writing the file is
+	// disabled when this is empty.
+	Path *string `mapstructure:"path"`
+
+	// Format is the format of the file. It takes the same values as the print
+	// format.
+	Format *string `mapstructure:"format"`
+
+	// Perms are the permissions of the file.
+	Perms *os.FileMode `mapstructure:"perms"`
+}
+
+func DefaultEnvFileConfig() *EnvFileConfig {
+	return &EnvFileConfig{}
+}
+
+func (c *EnvFileConfig) Copy() *EnvFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o EnvFileConfig
+
+	o.Path = c.Path
+
+	o.Format = c.Format
+
+	o.Perms = c.Perms
+
+	return &o
+}
+
+func (c *EnvFileConfig) Merge(o *EnvFileConfig) *EnvFileConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Path != nil {
+		r.Path = o.Path
+	}
+
+	if o.Format != nil {
+		r.Format = o.Format
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	return r
+}
+
+func (c *EnvFileConfig) Finalize() {
+	if c.Path == nil {
+		c.Path = config.String("")
+	}
+
+	if c.Format == nil {
+		c.Format = config.String(DefaultEnvFileFormat)
+	}
+
+	if c.Perms == nil {
+		c.Perms = config.FileMode(DefaultEnvFilePerms)
+	}
+}
+
+// Enabled returns true if the environment should be written to a file.
+func (c *EnvFileConfig) Enabled() bool {
+	return c != nil && config.StringPresent(c.Path)
+}
+
+func (c *EnvFileConfig) GoString() string {
+	if c == nil {
+		return "(*EnvFileConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&EnvFileConfig{"+
+		"Path:%s, "+
+		"Format:%s, "+
+		"Perms:%s"+
+		"}",
+		config.StringGoString(c.Path),
+		config.StringGoString(c.Format),
+		config.FileModeGoString(c.Perms),
+	)
+}
@@ -0,0 +1,148 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultFilesPerms are the permissions for secret files when none are
+	// configured.
+	DefaultFilesPerms os.FileMode = 0o600
+
+	// DefaultFilesEnv is the name of the environment variable which holds the
+	// secret files directory when none is configured.
+	DefaultFilesEnv = "ENVCONSUL_SECRETS_DIR"
+)
+
+// FilesConfig is the configuration for rendering the keys of a secret to
+// files on disk instead of environment variables.
+type FilesConfig struct {
+	// Dir is the directory the files are written to. Rendering to files is
+	// disabled when this is empty.
+	Dir *string `mapstructure:"dir"`
+
+	// Env is the name of the environment variable given to the child process
+	// which holds the path to Dir.
+	Env *string `mapstructure:"env"`
+
+	// Perms are the permissions of each file.
+	Perms *os.FileMode `mapstructure:"perms"`
+
+	// User and Group are the user and group names or ids that own each file.
+	User  *string `mapstructure:"user"`
+	Group *string `mapstructure:"group"`
+}
+
+func DefaultFilesConfig() *FilesConfig {
+	return &FilesConfig{}
+}
+
+func (c *FilesConfig) Copy() *FilesConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o FilesConfig
+
+	o.Dir = c.Dir
+
+	o.Env = c.Env
+
+	o.Perms = c.Perms
+
+	o.User = c.User
+
+	o.Group = c.Group
+
+	return &o
+}
+
+func (c *FilesConfig) Merge(o *FilesConfig) *FilesConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Dir != nil {
+		r.Dir = o.Dir
+	}
+
+	if o.Env != nil {
+		r.Env = o.Env
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	if o.User != nil {
+		r.User = o.User
+	}
+
+	if o.Group != nil {
+		r.Group = o.Group
+	}
+
+	return r
+}
+
+func (c *FilesConfig) Finalize() {
+	if c.Dir == nil {
+		c.Dir = config.String("")
+	}
+
+	if c.Env == nil {
+		c.Env = config.String(DefaultFilesEnv)
+	}
+
+	if c.Perms == nil {
+		c.Perms = config.FileMode(DefaultFilesPerms)
+	}
+
+	if c.User == nil {
+		c.User = config.String("")
+	}
+
+	if c.Group == nil {
+		c.Group = config.String("")
+	}
+}
+
+// Enabled returns true if keys should be rendered to files.
+func (c *FilesConfig) Enabled() bool {
+	return c != nil && config.StringPresent(c.Dir)
+}
+
+func (c *FilesConfig) GoString() string {
+	if c == nil {
+		return "(*FilesConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&FilesConfig{"+
+		"Dir:%s, "+
+		"Env:%s, "+
+		"Perms:%s, "+
+		"User:%s, "+
+		"Group:%s"+
+		"}",
+		config.StringGoString(c.Dir),
+		config.StringGoString(c.Env),
+		config.FileModeGoString(c.Perms),
+		config.StringGoString(c.User),
+		config.StringGoString(c.Group),
+	)
+}
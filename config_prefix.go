@@ -10,13 +10,43 @@ import (
 	"github.com/hashicorp/consul-template/config"
 )
 
-// KeyFormat wraps configuration for a particular key in secrets set.
+const (
+	// ValueEncodingString only accepts string values. Any other value is
+	// skipped, or is an error in strict mode.
+	ValueEncodingString = "string"
+
+	// ValueEncodingJSON converts scalars to strings and encodes objects and
+	// lists as JSON.
+	ValueEncodingJSON = "json"
+
+	// ValueEncodingFlatten converts scalars to strings, flattens nested objects
+	// into one key per value, joined by the separator, and encodes lists as
+	// JSON.
+	ValueEncodingFlatten = "flatten"
+
+	// DefaultSeparator is the default separator between the keys of
+	// flattened values.
+	DefaultSeparator = "_"
+
+	// DecodeJSON and DecodeYAML are the formats of documents stored in Consul
+	// which can be decoded into multiple variables.
+	DecodeJSON = "json"
+	DecodeYAML = "yaml"
+)
+
+// KeyFormat wraps configuration for a particular key in a prefix or secrets
+// set.
 //
 // Name is the name of the key
 // Format is per-key format override, just like PrefixConfig.Format
 type KeyFormat struct {
 	Format *string `mapstructure:"format"`
 	Name   *string `mapstructure:"name"`
+
+	// Default is the value of the key when it is missing, and Required holds
+	// up the run while the key is missing. They are only used by prefixes.
+	Default  *string `mapstructure:"default"`
+	Required *bool   `mapstructure:"required"`
 }
 
 func (f *KeyFormat) Copy() *KeyFormat {
@@ -27,6 +57,8 @@ func (f *KeyFormat) Copy() *KeyFormat {
 	r := KeyFormat{}
 	r.Name = f.Name
 	r.Format = f.Format
+	r.Default = f.Default
+	r.Required = f.Required
 
 	return &r
 }
@@ -54,6 +86,38 @@ type PrefixConfig struct {
 	NoPrefix *bool       `mapstructure:"no_prefix"`
 	Path     *string     `mapstructure:"path"`
 	Keys     *KeyFormats `mapstructure:"key"`
+
+	// Encoding controls how values which are not strings are converted. See
+	// the ValueEncoding constants. Separator joins the keys of flattened
+	// values. Strict fails the run instead of skipping values which cannot
+	// be converted.
+	Encoding  *string `mapstructure:"encoding"`
+	Separator *string `mapstructure:"separator"`
+	Strict    *bool   `mapstructure:"strict"`
+
+	// Decode is the format of the documents stored in each Consul key. When
+	// set, each document is flattened into one variable per value, with
+	// nested keys joined by Separator. It is only used by prefixes.
+	Decode *string `mapstructure:"decode"`
+
+	// Files renders the keys of a secret to files instead of environment
+	// variables. It is only used by secrets.
+	Files *FilesConfig `mapstructure:"files"`
+
+	// ChangeStrategy overrides the top-level change strategy when the values of
+	// this prefix or secret change.
+	ChangeStrategy *string `mapstructure:"change_strategy"`
+
+	// Optional marks the source as not required to start the child. When it
+	// returns an error, or no data within the optional timeout, it counts as
+	// empty.
+	Optional *bool `mapstructure:"optional"`
+
+	// Priority decides the order in which sources are merged. Sources with a
+	// higher priority are merged later, so their values take precedence. Sources
+	// with the same priority keep their order: prefixes, then services, then
+	// secrets, each in the order they are given.
+	Priority *int `mapstructure:"priority"`
 }
 
 func ParsePrefixConfig(s string) (*PrefixConfig, error) {
@@ -84,6 +148,24 @@ func (c *PrefixConfig) Copy() *PrefixConfig {
 		o.Keys = c.Keys.Copy()
 	}
 
+	if c.Files != nil {
+		o.Files = c.Files.Copy()
+	}
+
+	o.Encoding = c.Encoding
+
+	o.Separator = c.Separator
+
+	o.Strict = c.Strict
+
+	o.Decode = c.Decode
+
+	o.ChangeStrategy = c.ChangeStrategy
+
+	o.Optional = c.Optional
+
+	o.Priority = c.Priority
+
 	return &o
 }
 
@@ -117,6 +199,38 @@ func (c *PrefixConfig) Merge(o *PrefixConfig) *PrefixConfig {
 		r.Keys = o.Keys.Copy()
 	}
 
+	if o.Files != nil {
+		r.Files = r.Files.Merge(o.Files)
+	}
+
+	if o.Encoding != nil {
+		r.Encoding = o.Encoding
+	}
+
+	if o.Separator != nil {
+		r.Separator = o.Separator
+	}
+
+	if o.Strict != nil {
+		r.Strict = o.Strict
+	}
+
+	if o.Decode != nil {
+		r.Decode = o.Decode
+	}
+
+	if o.ChangeStrategy != nil {
+		r.ChangeStrategy = o.ChangeStrategy
+	}
+
+	if o.Optional != nil {
+		r.Optional = o.Optional
+	}
+
+	if o.Priority != nil {
+		r.Priority = o.Priority
+	}
+
 	return r
 }
 
@@ -133,6 +247,39 @@ func (c *PrefixConfig) Finalize() {
 	if c.Path == nil {
 		c.Path = config.String("")
 	}
+
+	if c.Files == nil {
+		c.Files = DefaultFilesConfig()
+	}
+	c.Files.Finalize()
+
+	if c.Encoding == nil {
+		c.Encoding = config.String(ValueEncodingString)
+	}
+
+	if c.Separator == nil {
+		c.Separator = config.String(DefaultSeparator)
+	}
+
+	if c.Strict == nil {
+		c.Strict = config.Bool(false)
+	}
+
+	if c.Decode == nil {
+		c.Decode = config.String("")
+	}
+
+	if c.ChangeStrategy == nil {
+		c.ChangeStrategy = config.String("")
+	}
+
+	if c.Optional == nil {
+		c.Optional = config.Bool(false)
+	}
+
+	if c.Priority == nil {
+		c.Priority = config.Int(0)
+	}
 }
 
 func (c *PrefixConfig) GoString() string {
@@ -143,11 +290,27 @@ func (c *PrefixConfig) GoString() string {
 	return fmt.Sprintf("&PrefixConfig{"+
 		"Format:%s, "+
 		"NoPrefix:%s, "+
-		"Path:%s"+
+		"Path:%s, "+
+		"Files:%s, "+
+		"Encoding:%s, "+
+		"Separator:%s, "+
+		"Strict:%s, "+
+		"Decode:%s, "+
+		"ChangeStrategy:%s, "+
+		"Optional:%s, "+
+		"Priority:%s"+
 		"}",
 		config.StringGoString(c.Format),
 		config.BoolGoString(c.NoPrefix),
 		config.StringGoString(c.Path),
+		c.Files.GoString(),
+		config.StringGoString(c.Encoding),
+		config.StringGoString(c.Separator),
+		config.BoolGoString(c.Strict),
+		config.StringGoString(c.Decode),
+		config.StringGoString(c.ChangeStrategy),
+		config.BoolGoString(c.Optional),
+		config.IntGoString(c.Priority),
 	)
 }
 
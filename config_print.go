@@ -0,0 +1,117 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultPrintPerms are the permissions used for the print file when none
+	// are configured. The rendered environment usually contains secrets, so it
+	// is only readable by the owner.
+	DefaultPrintPerms os.FileMode = 0o600
+)
+
+// PrintConfig is the configuration for printing the computed environment
+// instead of spawning a child process.
+type PrintConfig struct {
+	// Format is the output format. Printing is disabled when this is empty.
+	Format *string `mapstructure:"format"`
+
+	// Path is the file to write the environment to. When empty, the environment
+	// is written to standard out.
+	Path *string `mapstructure:"path"`
+
+	// Perms are the permissions of the file at Path.
+	Perms *os.FileMode `mapstructure:"perms"`
+}
+
+func DefaultPrintConfig() *PrintConfig {
+	return &PrintConfig{}
+}
+
+func (c *PrintConfig) Copy() *PrintConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o PrintConfig
+
+	o.Format = c.Format
+
+	o.Path = c.Path
+
+	o.Perms = c.Perms
+
+	return &o
+}
+
+func (c *PrintConfig) Merge(o *PrintConfig) *PrintConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Format != nil {
+		r.Format = o.Format
+	}
+
+	if o.Path != nil {
+		r.Path = o.Path
+	}
+
+	if o.Perms != nil {
+		r.Perms = o.Perms
+	}
+
+	return r
+}
+
+func (c *PrintConfig) Finalize() {
+	if c.Format == nil {
+		c.Format = config.String("")
+	}
+
+	if c.Path == nil {
+		c.Path = config.String("")
+	}
+
+	if c.Perms == nil {
+		c.Perms = config.FileMode(DefaultPrintPerms)
+	}
+}
+
+// Enabled returns true if the environment should be printed instead of
+// running a child process.
+func (c *PrintConfig) Enabled() bool {
+	return c != nil && config.StringPresent(c.Format)
+}
+
+func (c *PrintConfig) GoString() string {
+	if c == nil {
+		return "(*PrintConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&PrintConfig{"+
+		"Format:%s, "+
+		"Path:%s, "+
+		"Perms:%s"+
+		"}",
+		config.StringGoString(c.Format),
+		config.StringGoString(c.Path),
+		config.FileModeGoString(c.Perms),
+	)
+}
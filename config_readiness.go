@@ -0,0 +1,194 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// DefaultReadinessInterval is the time between two probes.
+	DefaultReadinessInterval = 1 * time.Second
+
+	// DefaultReadinessTimeout is how long a single probe may take.
+	DefaultReadinessTimeout = 1 * time.Second
+
+	// DefaultReadinessDeadline is how long to wait for a new child process to
+	// become ready.
+	DefaultReadinessDeadline = 1 * time.Minute
+)
+
+// ReadinessConfig is the configuration for probing whether the child process
+// is ready, such as listening for requests. It is set in the exec stanza, and
+// only one probe may be set.
+type ReadinessConfig struct {
+	// HTTP is a URL which must respond to a GET request with a 2xx or 3xx
+	// status code.
+	HTTP *string `mapstructure:"http"`
+
+	// TCP is an address, as host:port, which must accept connections.
+	TCP *string `mapstructure:"tcp"`
+
+	// Command is a command which must exit with a zero code. It is run with
+	// the environment of the child process.
+	Command *string `mapstructure:"command"`
+
+	// File is a path which must exist.
+	File *string `mapstructure:"file"`
+
+	// Interval is the time between two probes, and Timeout is how long a
+	// single probe may take.
+	Interval *time.Duration `mapstructure:"interval"`
+	Timeout  *time.Duration `mapstructure:"timeout"`
+
+	// Deadline is how long to wait for a new child process to become ready.
+	// Zero waits forever.
+	Deadline *time.Duration `mapstructure:"deadline"`
+}
+
+func DefaultReadinessConfig() *ReadinessConfig {
+	return &ReadinessConfig{}
+}
+
+func (c *ReadinessConfig) Copy() *ReadinessConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o ReadinessConfig
+
+	o.HTTP = c.HTTP
+
+	o.TCP = c.TCP
+
+	o.Command = c.Command
+
+	o.File = c.File
+
+	o.Interval = c.Interval
+
+	o.Timeout = c.Timeout
+
+	o.Deadline = c.Deadline
+
+	return &o
+}
+
+func (c *ReadinessConfig) Merge(o *ReadinessConfig) *ReadinessConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.HTTP != nil {
+		r.HTTP = o.HTTP
+	}
+
+	if o.TCP != nil {
+		r.TCP = o.TCP
+	}
+
+	if o.Command != nil {
+		r.Command = o.Command
+	}
+
+	if o.File != nil {
+		r.File = o.File
+	}
+
+	if o.Interval != nil {
+		r.Interval = o.Interval
+	}
+
+	if o.Timeout != nil {
+		r.Timeout = o.Timeout
+	}
+
+	if o.Deadline != nil {
+		r.Deadline = o.Deadline
+	}
+
+	return r
+}
+
+func (c *ReadinessConfig) Finalize() {
+	if c.HTTP == nil {
+		c.HTTP = config.String("")
+	}
+
+	if c.TCP == nil {
+		c.TCP = config.String("")
+	}
+
+	if c.Command == nil {
+		c.Command = config.String("")
+	}
+
+	if c.File == nil {
+		c.File = config.String("")
+	}
+
+	if c.Interval == nil {
+		c.Interval = config.TimeDuration(DefaultReadinessInterval)
+	}
+
+	if c.Timeout == nil {
+		c.Timeout = config.TimeDuration(DefaultReadinessTimeout)
+	}
+
+	if c.Deadline == nil {
+		c.Deadline = config.TimeDuration(DefaultReadinessDeadline)
+	}
+}
+
+// probes returns the number of probes which are set.
+func (c *ReadinessConfig) probes() int {
+	n := 0
+	for _, p := range []*string{c.HTTP, c.TCP, c.Command, c.File} {
+		if config.StringPresent(p) {
+			n++
+		}
+	}
+	return n
+}
+
+// Enabled returns true if the readiness of the child process is probed.
+func (c *ReadinessConfig) Enabled() bool {
+	return c != nil && c.probes() > 0
+}
+
+func (c *ReadinessConfig) GoString() string {
+	if c == nil {
+		return "(*ReadinessConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&ReadinessConfig{"+
+		"HTTP:%s, "+
+		"TCP:%s, "+
+		"Command:%s, "+
+		"File:%s, "+
+		"Interval:%s, "+
+		"Timeout:%s, "+
+		"Deadline:%s"+
+		"}",
+		config.StringGoString(c.HTTP),
+		config.StringGoString(c.TCP),
+		config.StringGoString(c.Command),
+		config.StringGoString(c.File),
+		config.TimeDurationGoString(c.Interval),
+		config.TimeDurationGoString(c.Timeout),
+		config.TimeDurationGoString(c.Deadline),
+	)
+}
@@ -0,0 +1,106 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// RedactModeMarker replaces redacted values with a fixed marker.
+	RedactModeMarker = "marker"
+
+	// RedactModeHash replaces redacted values with a short hash, so changes to
+	// a value can be told apart without revealing it.
+	RedactModeHash = "hash"
+)
+
+// RedactModes are the valid redact modes.
+var RedactModes = []string{RedactModeMarker, RedactModeHash}
+
+// DefaultRedactKeys are the globs of keys whose values are redacted when none
+// are configured.
+var DefaultRedactKeys = []string{"*PASSWORD*", "*TOKEN*"}
+
+// RedactConfig is the configuration for hiding the values of secrets and
+// sensitive keys in log output.
+type RedactConfig struct {
+	// Keys is a list of globs of keys whose values are redacted, in addition to
+	// the values of secrets. Keys are matched case-insensitively.
+	Keys []string `mapstructure:"keys"`
+
+	// Mode is how values are redacted, either "marker" or "hash".
+	Mode *string `mapstructure:"mode"`
+}
+
+func DefaultRedactConfig() *RedactConfig {
+	return &RedactConfig{}
+}
+
+func (c *RedactConfig) Copy() *RedactConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o RedactConfig
+
+	if c.Keys != nil {
+		o.Keys = append([]string{}, c.Keys...)
+	}
+
+	o.Mode = c.Mode
+
+	return &o
+}
+
+func (c *RedactConfig) Merge(o *RedactConfig) *RedactConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Keys != nil {
+		r.Keys = append([]string{}, o.Keys...)
+	}
+
+	if o.Mode != nil {
+		r.Mode = o.Mode
+	}
+
+	return r
+}
+
+func (c *RedactConfig) Finalize() {
+	if c.Keys == nil {
+		c.Keys = append([]string{}, DefaultRedactKeys...)
+	}
+
+	if c.Mode == nil {
+		c.Mode = config.String(RedactModeMarker)
+	}
+}
+
+func (c *RedactConfig) GoString() string {
+	if c == nil {
+		return "(*RedactConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&RedactConfig{"+
+		"Keys:%q, "+
+		"Mode:%s"+
+		"}",
+		c.Keys,
+		config.StringGoString(c.Mode),
+	)
+}
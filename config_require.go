@@ -0,0 +1,197 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// RequireTypeInt requires a base 10 integer.
+	RequireTypeInt = "int"
+
+	// RequireTypeBool requires a boolean, as accepted by strconv.ParseBool.
+	RequireTypeBool = "bool"
+
+	// RequireTypeURL requires an absolute URL, with a scheme and a host.
+	RequireTypeURL = "url"
+
+	// RequireTypeDuration requires a duration, as accepted by
+	// time.ParseDuration, such as "10s".
+	RequireTypeDuration = "duration"
+)
+
+// RequireTypes is the list of all supported types of required variables.
+var RequireTypes = []string{
+	RequireTypeInt,
+	RequireTypeBool,
+	RequireTypeURL,
+	RequireTypeDuration,
+}
+
+// RequireConfig is a variable which must be set in the environment of the
+// child process, with an optional check of its value. The child process is
+// not started, or not restarted, while any requirement fails.
+type RequireConfig struct {
+	// Name is the name of the variable, or a glob matching several variables,
+	// of which at least one must be set. Every matching variable is checked.
+	Name *string `mapstructure:"name"`
+
+	// Regex is a regular expression the value must match.
+	Regex *string `mapstructure:"regex"`
+
+	// Values is the list of allowed values.
+	Values []string `mapstructure:"values"`
+
+	// Type is the type the value must parse as. See the RequireType constants.
+	Type *string `mapstructure:"type"`
+}
+
+func DefaultRequireConfig() *RequireConfig {
+	return &RequireConfig{}
+}
+
+func (c *RequireConfig) Copy() *RequireConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o RequireConfig
+
+	o.Name = c.Name
+
+	o.Regex = c.Regex
+
+	if c.Values != nil {
+		o.Values = append([]string{}, c.Values...)
+	}
+
+	o.Type = c.Type
+
+	return &o
+}
+
+func (c *RequireConfig) Merge(o *RequireConfig) *RequireConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Name != nil {
+		r.Name = o.Name
+	}
+
+	if o.Regex != nil {
+		r.Regex = o.Regex
+	}
+
+	if o.Values != nil {
+		r.Values = append([]string{}, o.Values...)
+	}
+
+	if o.Type != nil {
+		r.Type = o.Type
+	}
+
+	return r
+}
+
+func (c *RequireConfig) Finalize() {
+	if c.Name == nil {
+		c.Name = config.String("")
+	}
+
+	if c.Regex == nil {
+		c.Regex = config.String("")
+	}
+
+	if c.Type == nil {
+		c.Type = config.String("")
+	}
+}
+
+func (c *RequireConfig) GoString() string {
+	if c == nil {
+		return "(*RequireConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&RequireConfig{"+
+		"Name:%s, "+
+		"Regex:%s, "+
+		"Values:%q, "+
+		"Type:%s"+
+		"}",
+		config.StringGoString(c.Name),
+		config.StringGoString(c.Regex),
+		c.Values,
+		config.StringGoString(c.Type),
+	)
+}
+
+type RequireConfigs []*RequireConfig
+
+func DefaultRequireConfigs() *RequireConfigs {
+	return &RequireConfigs{}
+}
+
+func (c *RequireConfigs) Copy() *RequireConfigs {
+	if c == nil {
+		return nil
+	}
+
+	o := make(RequireConfigs, len(*c))
+	for i, t := range *c {
+		o[i] = t.Copy()
+	}
+	return &o
+}
+
+func (c *RequireConfigs) Merge(o *RequireConfigs) *RequireConfigs {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	*r = append(*r, *o...)
+
+	return r
+}
+
+func (c *RequireConfigs) Finalize() {
+	for _, t := range *c {
+		t.Finalize()
+	}
+}
+
+func (c *RequireConfigs) GoString() string {
+	if c == nil {
+		return "(*RequireConfigs)(nil)"
+	}
+
+	s := make([]string, len(*c))
+	for i, t := range *c {
+		s[i] = t.GoString()
+	}
+
+	return "{" + strings.Join(s, ", ") + "}"
+}
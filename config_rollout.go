@@ -0,0 +1,82 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// RolloutConfig is the configuration for safe rollouts. When enabled, a change
+// of the environment which restarts the child process starts the new child
+// alongside the current one, and only stops the current child once the new
+// one has been running for the grace period. If the new child exits before,
+// the current child keeps running with the last environment which worked.
+type RolloutConfig struct {
+	// GracePeriod is how long the new child must run before the current child
+	// is stopped. Safe rollouts are disabled when this is zero.
+	GracePeriod *time.Duration `mapstructure:"grace_period"`
+}
+
+func DefaultRolloutConfig() *RolloutConfig {
+	return &RolloutConfig{}
+}
+
+func (c *RolloutConfig) Copy() *RolloutConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o RolloutConfig
+
+	o.GracePeriod = c.GracePeriod
+
+	return &o
+}
+
+func (c *RolloutConfig) Merge(o *RolloutConfig) *RolloutConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.GracePeriod != nil {
+		r.GracePeriod = o.GracePeriod
+	}
+
+	return r
+}
+
+func (c *RolloutConfig) Finalize() {
+	if c.GracePeriod == nil {
+		c.GracePeriod = config.TimeDuration(0)
+	}
+}
+
+// Enabled returns true if changes should be rolled out safely.
+func (c *RolloutConfig) Enabled() bool {
+	return c != nil && config.TimeDurationVal(c.GracePeriod) > 0
+}
+
+func (c *RolloutConfig) GoString() string {
+	if c == nil {
+		return "(*RolloutConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&RolloutConfig{"+
+		"GracePeriod:%s"+
+		"}",
+		config.TimeDurationGoString(c.GracePeriod),
+	)
+}
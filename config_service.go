@@ -10,6 +10,64 @@ import (
 	"github.com/hashicorp/consul-template/config"
 )
 
+const (
+	// ServiceSelectLast uses the last instance in the catalog for the single
+	// value variables. This is the default.
+	ServiceSelectLast = "last"
+
+	// ServiceSelectFirst uses the first instance in the catalog.
+	ServiceSelectFirst = "first"
+
+	// ServiceSelectRandom uses a random instance. The same instance is kept
+	// for as long as it is in the catalog, so the environment does not change
+	// on every update.
+	ServiceSelectRandom = "random"
+
+	// ServiceSelectNode uses the first instance sorted by node name.
+	ServiceSelectNode = "node"
+)
+
+const (
+	// ServiceHealthPassing only returns instances whose checks are passing.
+	ServiceHealthPassing = "passing"
+
+	// ServiceHealthWarning returns instances whose checks are passing or
+	// warning.
+	ServiceHealthWarning = "warning"
+)
+
+const (
+	// ServiceIncludeStatus adds the aggregated check status of the instance.
+	// This is only available when Health is set.
+	ServiceIncludeStatus = "status"
+
+	// ServiceIncludeMeta adds each ServiceMeta entry of the instance.
+	ServiceIncludeMeta = "meta"
+
+	// ServiceIncludeNode adds the name of the node of the instance.
+	ServiceIncludeNode = "node"
+
+	// ServiceIncludeTaggedAddresses adds each tagged address of the node of
+	// the instance.
+	ServiceIncludeTaggedAddresses = "tagged_addresses"
+)
+
+// ServiceIncludes is the list of all optional service variables.
+var ServiceIncludes = []string{
+	ServiceIncludeStatus,
+	ServiceIncludeMeta,
+	ServiceIncludeNode,
+	ServiceIncludeTaggedAddresses,
+}
+
+// ServiceSelects is the list of all supported selection strategies.
+var ServiceSelects = []string{
+	ServiceSelectLast,
+	ServiceSelectFirst,
+	ServiceSelectRandom,
+	ServiceSelectNode,
+}
+
 type ServiceConfig struct {
 	Query         *string `mapstructure:"query"`
 	FormatId      *string `mapstructure:"format_id"`
@@ -17,6 +75,49 @@ type ServiceConfig struct {
 	FormatAddress *string `mapstructure:"format_address"`
 	FormatTag     *string `mapstructure:"format_tag"`
 	FormatPort    *string `mapstructure:"format_port"`
+
+	// Select is the strategy used to pick the instance for the single value
+	// variables above. See the ServiceSelect constants.
+	Select *string `mapstructure:"select"`
+
+	// Indexed adds the id, name, address, tag and port of every instance,
+	// formatted with FormatIndexed, and the number of instances, formatted
+	// with FormatCount.
+	Indexed       *bool   `mapstructure:"indexed"`
+	FormatIndexed *string `mapstructure:"format_indexed"`
+	FormatCount   *string `mapstructure:"format_count"`
+
+	// Aggregate adds a comma separated list of the host:port of every
+	// instance, formatted with FormatAddresses, and the number of instances.
+	Aggregate       *bool   `mapstructure:"aggregate"`
+	FormatAddresses *string `mapstructure:"format_addresses"`
+
+	// Health queries the health endpoint instead of the catalog, so that only
+	// healthy instances are returned. See the ServiceHealth constants. The
+	// catalog is used when this is empty.
+	Health *string `mapstructure:"health"`
+
+	// Include is the list of optional variables to add for each instance. See
+	// the ServiceInclude constants. Each has its own format, where the key is
+	// the meta or tagged address key for FormatMeta and FormatTaggedAddress.
+	Include             []string `mapstructure:"include"`
+	FormatStatus        *string  `mapstructure:"format_status"`
+	FormatMeta          *string  `mapstructure:"format_meta"`
+	FormatNode          *string  `mapstructure:"format_node"`
+	FormatTaggedAddress *string  `mapstructure:"format_tagged_address"`
+
+	// ChangeStrategy overrides the top-level change strategy when the values of
+	// this service change.
+	ChangeStrategy *string `mapstructure:"change_strategy"`
+
+	// Optional marks the service as not required to start the child. When it
+	// returns an error, or no data within the optional timeout, it counts as
+	// empty.
+	Optional *bool `mapstructure:"optional"`
+
+	// Priority decides the order in which the service is merged with prefixes
+	// and secrets. See PrefixConfig.Priority.
+	Priority *int `mapstructure:"priority"`
 }
 
 func ParseServiceConfig(s string) (*ServiceConfig, error) {
@@ -39,14 +140,33 @@ func (s *ServiceConfig) Copy() *ServiceConfig {
 	if s == nil {
 		return nil
 	}
-	return &ServiceConfig{
-		Query:         s.Query,
-		FormatId:      s.FormatId,
-		FormatName:    s.FormatName,
-		FormatAddress: s.FormatAddress,
-		FormatTag:     s.FormatTag,
-		FormatPort:    s.FormatPort,
+	o := &ServiceConfig{
+		Query:           s.Query,
+		FormatId:        s.FormatId,
+		FormatName:      s.FormatName,
+		FormatAddress:   s.FormatAddress,
+		FormatTag:       s.FormatTag,
+		FormatPort:      s.FormatPort,
+		Select:          s.Select,
+		Indexed:         s.Indexed,
+		FormatIndexed:   s.FormatIndexed,
+		FormatCount:     s.FormatCount,
+		Aggregate:       s.Aggregate,
+		FormatAddresses: s.FormatAddresses,
+
+		Health:              s.Health,
+		FormatStatus:        s.FormatStatus,
+		FormatMeta:          s.FormatMeta,
+		FormatNode:          s.FormatNode,
+		FormatTaggedAddress: s.FormatTaggedAddress,
+		ChangeStrategy:      s.ChangeStrategy,
+		Optional:            s.Optional,
+		Priority:            s.Priority,
+	}
+	if s.Include != nil {
+		o.Include = append([]string{}, s.Include...)
 	}
+	return o
 }
 
 func (s *ServiceConfig) Merge(o *ServiceConfig) *ServiceConfig {
@@ -87,6 +207,66 @@ func (s *ServiceConfig) Merge(o *ServiceConfig) *ServiceConfig {
 		r.FormatPort = o.FormatPort
 	}
 
+	if o.Select != nil {
+		r.Select = o.Select
+	}
+
+	if o.Indexed != nil {
+		r.Indexed = o.Indexed
+	}
+
+	if o.FormatIndexed != nil {
+		r.FormatIndexed = o.FormatIndexed
+	}
+
+	if o.FormatCount != nil {
+		r.FormatCount = o.FormatCount
+	}
+
+	if o.Aggregate != nil {
+		r.Aggregate = o.Aggregate
+	}
+
+	if o.FormatAddresses != nil {
+		r.FormatAddresses = o.FormatAddresses
+	}
+
+	if o.Health != nil {
+		r.Health = o.Health
+	}
+
+	if o.Include != nil {
+		r.Include = append([]string{}, o.Include...)
+	}
+
+	if o.FormatStatus != nil {
+		r.FormatStatus = o.FormatStatus
+	}
+
+	if o.FormatMeta != nil {
+		r.FormatMeta = o.FormatMeta
+	}
+
+	if o.FormatNode != nil {
+		r.FormatNode = o.FormatNode
+	}
+
+	if o.FormatTaggedAddress != nil {
+		r.FormatTaggedAddress = o.FormatTaggedAddress
+	}
+
+	if o.ChangeStrategy != nil {
+		r.ChangeStrategy = o.ChangeStrategy
+	}
+
+	if o.Optional != nil {
+		r.Optional = o.Optional
+	}
+
+	if o.Priority != nil {
+		r.Priority = o.Priority
+	}
+
 	return r
 }
 
@@ -114,6 +294,66 @@ func (s *ServiceConfig) Finalize() {
 	if s.FormatPort == nil {
 		s.FormatPort = config.String("")
 	}
+
+	if s.Select == nil {
+		s.Select = config.String(ServiceSelectLast)
+	}
+
+	if s.Indexed == nil {
+		s.Indexed = config.Bool(false)
+	}
+
+	if s.FormatIndexed == nil {
+		s.FormatIndexed = config.String("")
+	}
+
+	if s.FormatCount == nil {
+		s.FormatCount = config.String("")
+	}
+
+	if s.Aggregate == nil {
+		s.Aggregate = config.Bool(false)
+	}
+
+	if s.FormatAddresses == nil {
+		s.FormatAddresses = config.String("")
+	}
+
+	if s.Health == nil {
+		s.Health = config.String("")
+	}
+
+	if s.Include == nil {
+		s.Include = []string{}
+	}
+
+	if s.FormatStatus == nil {
+		s.FormatStatus = config.String("")
+	}
+
+	if s.FormatMeta == nil {
+		s.FormatMeta = config.String("")
+	}
+
+	if s.FormatNode == nil {
+		s.FormatNode = config.String("")
+	}
+
+	if s.FormatTaggedAddress == nil {
+		s.FormatTaggedAddress = config.String("")
+	}
+
+	if s.ChangeStrategy == nil {
+		s.ChangeStrategy = config.String("")
+	}
+
+	if s.Optional == nil {
+		s.Optional = config.Bool(false)
+	}
+
+	if s.Priority == nil {
+		s.Priority = config.Int(0)
+	}
 }
 
 func (s *ServiceConfig) GoString() string {
@@ -127,7 +367,22 @@ func (s *ServiceConfig) GoString() string {
 		"FormatName:%s, "+
 		"FormatAddress:%s, "+
 		"FormatTag:%s, "+
-		"FormatPort:%s"+
+		"FormatPort:%s, "+
+		"Select:%s, "+
+		"Indexed:%s, "+
+		"FormatIndexed:%s, "+
+		"FormatCount:%s, "+
+		"Aggregate:%s, "+
+		"FormatAddresses:%s, "+
+		"Health:%s, "+
+		"Include:%q, "+
+		"FormatStatus:%s, "+
+		"FormatMeta:%s, "+
+		"FormatNode:%s, "+
+		"FormatTaggedAddress:%s, "+
+		"ChangeStrategy:%s, "+
+		"Optional:%s, "+
+		"Priority:%s"+
 		"}",
 		config.StringGoString(s.Query),
 		config.StringGoString(s.FormatId),
@@ -135,6 +390,21 @@ func (s *ServiceConfig) GoString() string {
 		config.StringGoString(s.FormatAddress),
 		config.StringGoString(s.FormatTag),
 		config.StringGoString(s.FormatPort),
+		config.StringGoString(s.Select),
+		config.BoolGoString(s.Indexed),
+		config.StringGoString(s.FormatIndexed),
+		config.StringGoString(s.FormatCount),
+		config.BoolGoString(s.Aggregate),
+		config.StringGoString(s.FormatAddresses),
+		config.StringGoString(s.Health),
+		s.Include,
+		config.StringGoString(s.FormatStatus),
+		config.StringGoString(s.FormatMeta),
+		config.StringGoString(s.FormatNode),
+		config.StringGoString(s.FormatTaggedAddress),
+		config.StringGoString(s.ChangeStrategy),
+		config.BoolGoString(s.Optional),
+		config.IntGoString(s.Priority),
 	)
 }
 
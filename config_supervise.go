@@ -0,0 +1,187 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// SupervisePolicyNever never restarts the child process. envconsul exits
+	// with the child's exit code. This is the default.
+	SupervisePolicyNever = "never"
+
+	// SupervisePolicyOnFailure restarts the child process when it exits with
+	// a non-zero code.
+	SupervisePolicyOnFailure = "on-failure"
+
+	// SupervisePolicyAlways restarts the child process whenever it exits.
+	SupervisePolicyAlways = "always"
+)
+
+// SupervisePolicies is the list of all supported supervise policies.
+var SupervisePolicies = []string{
+	SupervisePolicyNever,
+	SupervisePolicyOnFailure,
+	SupervisePolicyAlways,
+}
+
+const (
+	// DefaultSuperviseBackoff is the delay before the first restart.
+	DefaultSuperviseBackoff = 1 * time.Second
+
+	// DefaultSuperviseMaxBackoff is the longest delay between restarts.
+	DefaultSuperviseMaxBackoff = 1 * time.Minute
+
+	// DefaultSuperviseResetAfter is how long the child must run before the
+	// backoff is reset.
+	DefaultSuperviseResetAfter = 5 * time.Minute
+
+	// DefaultSuperviseMaxRestarts is the number of restarts allowed inside the
+	// window before giving up.
+	DefaultSuperviseMaxRestarts = 5
+
+	// DefaultSuperviseWindow is the window the restarts are counted in.
+	DefaultSuperviseWindow = 10 * time.Minute
+)
+
+// SuperviseConfig is the configuration for restarting the child process when
+// it exits on its own.
+type SuperviseConfig struct {
+	// Policy is when to restart the child. See the SupervisePolicy constants.
+	Policy *string `mapstructure:"policy"`
+
+	// Backoff is the delay before the first restart. It doubles on every
+	// restart, up to MaxBackoff, with random jitter applied.
+	Backoff    *time.Duration `mapstructure:"backoff"`
+	MaxBackoff *time.Duration `mapstructure:"max_backoff"`
+
+	// ResetAfter is how long the child must run before the backoff goes back
+	// to its initial value.
+	ResetAfter *time.Duration `mapstructure:"reset_after"`
+
+	// MaxRestarts is the number of restarts allowed inside Window. When it is
+	// reached, envconsul gives up and exits with the child's last exit code.
+	// Zero allows unlimited restarts.
+	MaxRestarts *int           `mapstructure:"max_restarts"`
+	Window      *time.Duration `mapstructure:"window"`
+}
+
+func DefaultSuperviseConfig() *SuperviseConfig {
+	return &SuperviseConfig{}
+}
+
+func (c *SuperviseConfig) Copy() *SuperviseConfig {
+	if c == nil {
+		return nil
+	}
+
+	var o SuperviseConfig
+
+	o.Policy = c.Policy
+
+	o.Backoff = c.Backoff
+
+	o.MaxBackoff = c.MaxBackoff
+
+	o.ResetAfter = c.ResetAfter
+
+	o.MaxRestarts = c.MaxRestarts
+
+	o.Window = c.Window
+
+	return &o
+}
+
+func (c *SuperviseConfig) Merge(o *SuperviseConfig) *SuperviseConfig {
+	if c == nil {
+		if o == nil {
+			return nil
+		}
+		return o.Copy()
+	}
+
+	if o == nil {
+		return c.Copy()
+	}
+
+	r := c.Copy()
+
+	if o.Policy != nil {
+		r.Policy = o.Policy
+	}
+
+	if o.Backoff != nil {
+		r.Backoff = o.Backoff
+	}
+
+	if o.MaxBackoff != nil {
+		r.MaxBackoff = o.MaxBackoff
+	}
+
+	if o.ResetAfter != nil {
+		r.ResetAfter = o.ResetAfter
+	}
+
+	if o.MaxRestarts != nil {
+		r.MaxRestarts = o.MaxRestarts
+	}
+
+	if o.Window != nil {
+		r.Window = o.Window
+	}
+
+	return r
+}
+
+func (c *SuperviseConfig) Finalize() {
+	if c.Policy == nil {
+		c.Policy = config.String(SupervisePolicyNever)
+	}
+
+	if c.Backoff == nil {
+		c.Backoff = config.TimeDuration(DefaultSuperviseBackoff)
+	}
+
+	if c.MaxBackoff == nil {
+		c.MaxBackoff = config.TimeDuration(DefaultSuperviseMaxBackoff)
+	}
+
+	if c.ResetAfter == nil {
+		c.ResetAfter = config.TimeDuration(DefaultSuperviseResetAfter)
+	}
+
+	if c.MaxRestarts == nil {
+		c.MaxRestarts = config.Int(DefaultSuperviseMaxRestarts)
+	}
+
+	if c.Window == nil {
+		c.Window = config.TimeDuration(DefaultSuperviseWindow)
+	}
+}
+
+func (c *SuperviseConfig) GoString() string {
+	if c == nil {
+		return "(*SuperviseConfig)(nil)"
+	}
+
+	return fmt.Sprintf("&SuperviseConfig{"+
+		"Policy:%s, "+
+		"Backoff:%s, "+
+		"MaxBackoff:%s, "+
+		"ResetAfter:%s, "+
+		"MaxRestarts:%s, "+
+		"Window:%s"+
+		"}",
+		config.StringGoString(c.Policy),
+		config.TimeDurationGoString(c.Backoff),
+		config.TimeDurationGoString(c.MaxBackoff),
+		config.TimeDurationGoString(c.ResetAfter),
+		config.IntGoString(c.MaxRestarts),
+		config.TimeDurationGoString(c.Window),
+	)
+}
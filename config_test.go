@@ -484,6 +484,38 @@ func TestParse(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"exec_readiness",
+			`exec {
+				command = "command"
+				readiness {
+					tcp      = "127.0.0.1:8080"
+					interval = "2s"
+					timeout  = "500ms"
+					deadline = "30s"
+				}
+			}`,
+			&Config{
+				Exec: &config.ExecConfig{
+					Command: []string{"command"},
+				},
+				Readiness: &ReadinessConfig{
+					TCP:      config.String("127.0.0.1:8080"),
+					Interval: config.TimeDuration(2 * time.Second),
+					Timeout:  config.TimeDuration(500 * time.Millisecond),
+					Deadline: config.TimeDuration(30 * time.Second),
+				},
+			},
+			false,
+		},
+		{
+			"readiness_top_level",
+			`readiness {
+				file = "/tmp/ready"
+			}`,
+			nil,
+			true,
+		},
 		{
 			"exec_env_denylist",
 			`exec {
@@ -672,6 +704,38 @@ func TestParse(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"print",
+			`print {
+				format = "systemd"
+				path   = "/etc/app.env"
+				perms  = "0640"
+			}`,
+			&Config{
+				Print: &PrintConfig{
+					Format: config.String("systemd"),
+					Path:   config.String("/etc/app.env"),
+					Perms:  config.FileMode(0o640),
+				},
+			},
+			false,
+		},
+		{
+			"prefix_decode",
+			`prefix {
+				decode    = "yaml"
+				separator = "."
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Decode:    config.String("yaml"),
+						Separator: config.String("."),
+					},
+				},
+			},
+			false,
+		},
 		{
 			"prefix",
 			`prefix {}`,
@@ -766,6 +830,18 @@ func TestParse(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"rollout",
+			`rollout {
+				grace_period = "10s"
+			}`,
+			&Config{
+				Rollout: &RolloutConfig{
+					GracePeriod: config.TimeDuration(10 * time.Second),
+				},
+			},
+			false,
+		},
 		{
 			"sanitize",
 			`sanitize = true`,
@@ -810,6 +886,50 @@ func TestParse(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"secret_files",
+			`secret {
+				files {
+					dir   = "/run/secrets/app"
+					env   = "APP_SECRETS"
+					perms = "0400"
+					user  = "app"
+					group = "app"
+				}
+			}`,
+			&Config{
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Files: &FilesConfig{
+							Dir:   config.String("/run/secrets/app"),
+							Env:   config.String("APP_SECRETS"),
+							Perms: config.FileMode(0o400),
+							User:  config.String("app"),
+							Group: config.String("app"),
+						},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"secret_encoding",
+			`secret {
+				encoding  = "flatten"
+				separator = "__"
+				strict    = true
+			}`,
+			&Config{
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Encoding:  config.String("flatten"),
+						Separator: config.String("__"),
+						Strict:    config.Bool(true),
+					},
+				},
+			},
+			false,
+		},
 		{
 			"secret_no_prefix",
 			`secret {
@@ -888,6 +1008,331 @@ func TestParse(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"service_instances",
+			`service {
+				query = "foo"
+				select = "random"
+				indexed = true
+				format_indexed = "{{ service }}_{{ index }}_{{ key }}"
+				format_count = "{{ service }}_total"
+				aggregate = true
+				format_addresses = "{{ service }}_hosts"
+			}`,
+			&Config{
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:           config.String("foo"),
+						Select:          config.String("random"),
+						Indexed:         config.Bool(true),
+						FormatIndexed:   config.String("{{ service }}_{{ index }}_{{ key }}"),
+						FormatCount:     config.String("{{ service }}_total"),
+						Aggregate:       config.Bool(true),
+						FormatAddresses: config.String("{{ service }}_hosts"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"service_health",
+			`service {
+				query = "foo"
+				health = "passing"
+				include = ["status", "meta", "node", "tagged_addresses"]
+				format_status = "{{ service }}_health"
+				format_meta = "{{ service }}_meta_{{ key }}"
+				format_node = "{{ service }}_host"
+				format_tagged_address = "{{ service }}_addr_{{ key }}"
+			}`,
+			&Config{
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:               config.String("foo"),
+						Health:              config.String("passing"),
+						Include:             []string{"status", "meta", "node", "tagged_addresses"},
+						FormatStatus:        config.String("{{ service }}_health"),
+						FormatMeta:          config.String("{{ service }}_meta_{{ key }}"),
+						FormatNode:          config.String("{{ service }}_host"),
+						FormatTaggedAddress: config.String("{{ service }}_addr_{{ key }}"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"audit",
+			`audit {
+				path  = "/var/log/envconsul/audit.jsonl"
+				perms = "0640"
+			}`,
+			&Config{
+				Audit: &AuditConfig{
+					Path:  config.String("/var/log/envconsul/audit.jsonl"),
+					Perms: config.FileMode(0o640),
+				},
+			},
+			false,
+		},
+		{
+			"cache",
+			`cache {
+				path     = "/var/cache/envconsul.json"
+				deadline = "30s"
+				key_file = "/etc/envconsul/cache.key"
+				key_env  = "CACHE_KEY"
+				perms    = "0640"
+			}`,
+			&Config{
+				Cache: &CacheConfig{
+					Path:     config.String("/var/cache/envconsul.json"),
+					Deadline: config.TimeDuration(30 * time.Second),
+					KeyFile:  config.String("/etc/envconsul/cache.key"),
+					KeyEnv:   config.String("CACHE_KEY"),
+					Perms:    config.FileMode(0o640),
+				},
+			},
+			false,
+		},
+		{
+			"change_strategy",
+			`change_strategy = "signal"
+			env_file {
+				path   = "/run/app.env"
+				format = "systemd"
+				perms  = "0640"
+			}
+			prefix {
+				path            = "foo"
+				change_strategy = "restart"
+			}
+			service {
+				query           = "bar"
+				change_strategy = "exit"
+			}`,
+			&Config{
+				ChangeStrategy: config.String("signal"),
+				EnvFile: &EnvFileConfig{
+					Path:   config.String("/run/app.env"),
+					Format: config.String("systemd"),
+					Perms:  config.FileMode(0o640),
+				},
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Path:           config.String("foo"),
+						ChangeStrategy: config.String("restart"),
+					},
+				},
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:          config.String("bar"),
+						ChangeStrategy: config.String("exit"),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"conflict_policy",
+			`conflict_policy = "error"`,
+			&Config{
+				ConflictPolicy: config.String("error"),
+			},
+			false,
+		},
+		{
+			"restart_on",
+			`restart_on = ["APP_*"]
+			ignore_changes = ["*_VERSION", "LOG_LEVEL"]`,
+			&Config{
+				RestartOn:     []string{"APP_*"},
+				IgnoreChanges: []string{"*_VERSION", "LOG_LEVEL"},
+			},
+			false,
+		},
+		{
+			"optional",
+			`optional_timeout = "10s"
+			prefix {
+				path     = "foo"
+				optional = true
+			}
+			secret {
+				path     = "secret/foo"
+				optional = true
+			}
+			service {
+				query    = "bar"
+				optional = true
+			}`,
+			&Config{
+				OptionalTimeout: config.TimeDuration(10 * time.Second),
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Path:     config.String("foo"),
+						Optional: config.Bool(true),
+					},
+				},
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Path:     config.String("secret/foo"),
+						Optional: config.Bool(true),
+					},
+				},
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:    config.String("bar"),
+						Optional: config.Bool(true),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"prefix_keys",
+			`prefix {
+				path = "foo"
+				key {
+					name   = "host"
+					format = "DB_HOST"
+				}
+				key {
+					name    = "port"
+					default = "5432"
+				}
+				key {
+					name     = "password"
+					required = true
+				}
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Path: config.String("foo"),
+						Keys: &KeyFormats{
+							&KeyFormat{Name: config.String("host"), Format: config.String("DB_HOST")},
+							&KeyFormat{Name: config.String("port"), Default: config.String("5432")},
+							&KeyFormat{Name: config.String("password"), Required: config.Bool(true)},
+						},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"priority",
+			`prefix {
+				path     = "foo"
+				priority = 10
+			}
+			secret {
+				path = "secret/foo"
+			}
+			service {
+				query    = "bar"
+				priority = -1
+			}`,
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Path:     config.String("foo"),
+						Priority: config.Int(10),
+					},
+				},
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Path: config.String("secret/foo"),
+					},
+				},
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:    config.String("bar"),
+						Priority: config.Int(-1),
+					},
+				},
+			},
+			false,
+		},
+		{
+			"mask_output",
+			`mask_output = true`,
+			&Config{
+				MaskOutput: config.Bool(true),
+			},
+			false,
+		},
+		{
+			"redact",
+			`log_unredacted = true
+			redact {
+				keys = ["*_KEY"]
+				mode = "hash"
+			}`,
+			&Config{
+				LogUnredacted: config.Bool(true),
+				Redact: &RedactConfig{
+					Keys: []string{"*_KEY"},
+					Mode: config.String("hash"),
+				},
+			},
+			false,
+		},
+		{
+			"require",
+			`require {
+				name = "PORT"
+				type = "int"
+			}
+			require {
+				name   = "MODE"
+				regex  = "^[a-z]+$"
+				values = ["dev", "prod"]
+			}`,
+			&Config{
+				Require: &RequireConfigs{
+					&RequireConfig{
+						Name: config.String("PORT"),
+						Type: config.String("int"),
+					},
+					&RequireConfig{
+						Name:   config.String("MODE"),
+						Regex:  config.String("^[a-z]+$"),
+						Values: []string{"dev", "prod"},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"startup_timeout",
+			`startup_timeout = "30s"`,
+			&Config{
+				StartupTimeout: config.TimeDuration(30 * time.Second),
+			},
+			false,
+		},
+		{
+			"supervise",
+			`supervise {
+				policy       = "on-failure"
+				backoff      = "2s"
+				max_backoff  = "30s"
+				reset_after  = "1m"
+				max_restarts = 3
+				window       = "5m"
+			}`,
+			&Config{
+				Supervise: &SuperviseConfig{
+					Policy:      config.String("on-failure"),
+					Backoff:     config.TimeDuration(2 * time.Second),
+					MaxBackoff:  config.TimeDuration(30 * time.Second),
+					ResetAfter:  config.TimeDuration(1 * time.Minute),
+					MaxRestarts: config.Int(3),
+					Window:      config.TimeDuration(5 * time.Minute),
+				},
+			},
+			false,
+		},
 		{
 			"syslog",
 			`syslog {}`,
@@ -1471,6 +1916,25 @@ func TestConfig_Merge(t *testing.T) {
 				PidFile: config.String("pid_file-diff"),
 			},
 		},
+		{
+			"print",
+			&Config{
+				Print: &PrintConfig{
+					Format: config.String("json"),
+				},
+			},
+			&Config{
+				Print: &PrintConfig{
+					Path: config.String("/etc/app.env"),
+				},
+			},
+			&Config{
+				Print: &PrintConfig{
+					Format: config.String("json"),
+					Path:   config.String("/etc/app.env"),
+				},
+			},
+		},
 		{
 			"prefix",
 			&Config{
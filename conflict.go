@@ -0,0 +1,137 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// ConflictPolicyLastWins keeps the value of the last source which set a
+	// key. This is the default.
+	ConflictPolicyLastWins = "last-wins"
+
+	// ConflictPolicyFirstWins keeps the value of the first source which set a
+	// key, with the parent environment first.
+	ConflictPolicyFirstWins = "first-wins"
+
+	// ConflictPolicyWarn keeps the value of the last source, and logs a
+	// warning.
+	ConflictPolicyWarn = "warn"
+
+	// ConflictPolicyError stops the run with an error.
+	ConflictPolicyError = "error"
+)
+
+// ConflictPolicies is the list of all supported conflict policies.
+var ConflictPolicies = []string{
+	ConflictPolicyLastWins,
+	ConflictPolicyFirstWins,
+	ConflictPolicyWarn,
+	ConflictPolicyError,
+}
+
+// validConflictPolicy returns true if the given policy is a known conflict
+// policy.
+func validConflictPolicy(s string) bool {
+	for _, p := range ConflictPolicies {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrConflict is returned when a key is set to different values by two
+// sources, and the conflict policy is "error".
+type ErrConflict struct {
+	Key         string
+	First, Last string
+}
+
+func (e *ErrConflict) Error() string {
+	return fmt.Sprintf("conflicting values for %s, set by %s and by %s",
+		e.Key, e.First, e.Last)
+}
+
+// describeOrigin returns a description of where a key came from for conflict
+// messages. Keys from the same source with different raw keys, like "my-key"
+// and "my_key" once sanitized, are told apart by their raw key.
+func describeOrigin(o *keyOrigin) string {
+	if o.RawKey == "" {
+		return o.Source
+	}
+	return fmt.Sprintf("%q from %s", o.RawKey, o.Source)
+}
+
+// resolveConflict applies the conflict policy to a key which is already set to
+// current by prev, and is set to value by next. It returns true if the new
+// value replaces the current one. Setting a key to the value it already has is
+// not a conflict.
+func (r *Runner) resolveConflict(
+	key, current, value string, prev, next *keyOrigin,
+) (bool, error) {
+	if current == value {
+		return true, nil
+	}
+
+	logger := namedLogger("runner")
+
+	switch config.StringVal(r.config.ConflictPolicy) {
+	case ConflictPolicyFirstWins:
+		logger.Debug(fmt.Sprintf("keeping %s=%q from %s, ignoring %q from %s",
+			key, r.redactor.value(key, current, prev.secret), describeOrigin(prev),
+			r.redactor.value(key, value, next.secret), describeOrigin(next)))
+		return false, nil
+	case ConflictPolicyWarn:
+		logger.Warn(fmt.Sprintf("conflicting values for %s: %q from %s overrides "+
+			"%q from %s", key,
+			r.redactor.value(key, value, next.secret), describeOrigin(next),
+			r.redactor.value(key, current, prev.secret), describeOrigin(prev)))
+	case ConflictPolicyError:
+		return false, &ErrConflict{
+			Key:   key,
+			First: describeOrigin(prev),
+			Last:  describeOrigin(next),
+		}
+	}
+	return true, nil
+}
+
+// resolveParentConflicts applies the conflict policy to the keys of env which
+// are also set in the parent environment, unless pristine. Keys which the
+// parent environment keeps are removed from env and from the environment of
+// the dependency which set them, so that the child process inherits them. The
+// caller must hold the dependencies lock.
+func (r *Runner) resolveParentConflicts(
+	env map[string]string, depEnv map[string]map[string]string,
+	origins map[string]*keyOrigin,
+) error {
+	if config.BoolVal(r.config.Pristine) {
+		return nil
+	}
+
+	parent := &keyOrigin{Source: explainSourceParent}
+	for _, v := range os.Environ() {
+		list := strings.SplitN(v, "=", 2)
+		value, ok := env[list[0]]
+		if !ok {
+			continue
+		}
+		origin := origins[list[0]]
+		replace, err := r.resolveConflict(list[0], list[1], value, parent, origin)
+		if err != nil {
+			return err
+		}
+		if !replace {
+			delete(env, list[0])
+			delete(depEnv[origin.Source], list[0])
+		}
+	}
+	return nil
+}
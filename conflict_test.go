@@ -0,0 +1,245 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRunner_buildEnv_conflictPolicy(t *testing.T) {
+	t.Setenv("APP_ENV", "parent")
+
+	cases := []struct {
+		name     string
+		policy   string
+		pristine bool
+		exp      map[string]string
+		err      bool
+	}{
+		{
+			"last_wins",
+			ConflictPolicyLastWins,
+			false,
+			map[string]string{"MY_KEY": "underscore", "HOST": "app", "APP_ENV": "app"},
+			false,
+		},
+		{
+			"first_wins",
+			ConflictPolicyFirstWins,
+			false,
+			map[string]string{"MY_KEY": "dash", "HOST": "shared"},
+			false,
+		},
+		{
+			"first_wins_pristine",
+			ConflictPolicyFirstWins,
+			true,
+			map[string]string{"MY_KEY": "dash", "HOST": "shared", "APP_ENV": "app"},
+			false,
+		},
+		{
+			"warn",
+			ConflictPolicyWarn,
+			false,
+			map[string]string{"MY_KEY": "underscore", "HOST": "app", "APP_ENV": "app"},
+			false,
+		},
+		{
+			"error",
+			ConflictPolicyError,
+			false,
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{
+				ConflictPolicy: config.String(tc.policy),
+				Pristine:       config.Bool(tc.pristine),
+				Sanitize:       config.Bool(true),
+				Upcase:         config.Bool(true),
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("shared")},
+					&PrefixConfig{Path: config.String("app")},
+				},
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r.Receive(r.dependencies[0], []*dep.KeyPair{
+				{Key: "host", Value: "shared"},
+				{Key: "my-key", Value: "dash"},
+				{Key: "my_key", Value: "underscore"},
+			})
+			r.Receive(r.dependencies[1], []*dep.KeyPair{
+				{Key: "app_env", Value: "app"},
+				{Key: "host", Value: "app"},
+				{Key: "my_key", Value: "underscore"},
+			})
+
+			env, _, _, err := r.buildEnv()
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(env, tc.exp) {
+				t.Errorf("expected %v, got %v", tc.exp, env)
+			}
+		})
+	}
+}
+
+func TestRunner_buildEnv_conflictSameValue(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		ConflictPolicy: config.String(ConflictPolicyError),
+		Pristine:       config.Bool(true),
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("shared")},
+			&PrefixConfig{Path: config.String("app")},
+		},
+	})
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "host", Value: "db"}})
+	r.Receive(r.dependencies[1], []*dep.KeyPair{{Key: "host", Value: "db"}})
+
+	if _, _, _, err := r.buildEnv(); err != nil {
+		t.Errorf("expected equal values not to conflict, got %v", err)
+	}
+}
+
+func TestRunner_buildEnv_conflictUpcase(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		policy string
+		exp    string
+	}{
+		{"first_wins", ConflictPolicyFirstWins, "upper"},
+		{"last_wins", ConflictPolicyLastWins, "lower"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{
+				ConflictPolicy: config.String(tc.policy),
+				Pristine:       config.Bool(true),
+				Upcase:         config.Bool(true),
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+				},
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Receive(r.dependencies[0], &dep.Secret{
+				Data: map[string]interface{}{"token": "lower", "TOKEN": "upper"},
+			})
+
+			// Keys from the same source which collide after being upcased are
+			// resolved in key order, so the result is the same on every run.
+			for i := 0; i < 20; i++ {
+				env, _, _, err := r.buildEnv()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if env["TOKEN"] != tc.exp {
+					t.Fatalf("run %d: expected %q, got %q", i, tc.exp, env["TOKEN"])
+				}
+			}
+		})
+	}
+}
+
+func TestRunner_buildEnv_conflictSecretFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "secrets")
+	newRunner := func(t *testing.T, policy string) *Runner {
+		c := DefaultConfig().Merge(&Config{
+			ConflictPolicy: config.String(policy),
+			Pristine:       config.Bool(true),
+			Upcase:         config.Bool(true),
+			Prefixes: &PrefixConfigs{
+				&PrefixConfig{Path: config.String("app")},
+			},
+			Secrets: &PrefixConfigs{
+				&PrefixConfig{
+					Path:     config.String("secret/app"),
+					NoPrefix: config.Bool(true),
+					Files:    &FilesConfig{Dir: config.String(dir)},
+				},
+			},
+		})
+		r, err := NewRunner(c, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("env", func(t *testing.T) {
+		r := newRunner(t, ConflictPolicyError)
+		r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: DefaultFilesEnv, Value: "/tmp"}})
+		r.Receive(r.dependencies[1], &dep.Secret{
+			Data: map[string]interface{}{"token": "hunter2"},
+		})
+		_, _, _, err := r.buildEnv()
+		if _, ok := err.(*ErrConflict); !ok {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		r := newRunner(t, ConflictPolicyError)
+		r.Receive(r.dependencies[0], []*dep.KeyPair{})
+		r.Receive(r.dependencies[1], &dep.Secret{
+			Data: map[string]interface{}{"token": "hunter2"},
+		})
+		env, _, _, err := r.buildEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env[DefaultFilesEnv] != dir {
+			t.Fatalf("expected %s to be %q, got %q", DefaultFilesEnv, dir, env[DefaultFilesEnv])
+		}
+		if origin := r.origins["vault.read(secret/app)"][DefaultFilesEnv]; origin == nil || origin.secret {
+			t.Errorf("expected a source which is not secret, got %#v", origin)
+		}
+		if r.secretKeys[DefaultFilesEnv] {
+			t.Errorf("expected %s not to be redacted", DefaultFilesEnv)
+		}
+	})
+
+	t.Run("path", func(t *testing.T) {
+		r := newRunner(t, ConflictPolicyError)
+		r.Receive(r.dependencies[0], []*dep.KeyPair{})
+		r.Receive(r.dependencies[1], &dep.Secret{
+			Data: map[string]interface{}{"token": "lower", "TOKEN": "upper"},
+		})
+		_, _, _, err := r.buildEnv()
+		typed, ok := err.(*ErrConflict)
+		if !ok {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+		if exp := filepath.Join(dir, "TOKEN"); typed.Key != exp {
+			t.Errorf("expected the conflict on %q, got %q", exp, typed.Key)
+		}
+	})
+}
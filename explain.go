@@ -0,0 +1,217 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+const (
+	// explainTimeout is how long explain waits for every dependency to return
+	// data when no startup timeout is configured.
+	explainTimeout = time.Minute
+
+	// explainSourceParent is the source of values from the environment of
+	// envconsul.
+	explainSourceParent = "parent environment"
+)
+
+// explainedVar is a variable set by envconsul, along with where it came from
+// and the values it overrode, from the lowest precedence.
+type explainedVar struct {
+	Key       string
+	Value     string
+	Origin    *keyOrigin
+	Overrides []*explainedValue
+}
+
+// explainedValue is a value of a variable from one source.
+type explainedValue struct {
+	Source string
+	Value  string
+	secret bool
+}
+
+// fetch waits for every dependency to return data, or to count as empty when
+// it is optional. It returns an ErrStartupTimeout when the timeout is reached
+// first.
+func (r *Runner) fetch(timeout time.Duration) error {
+	for _, d := range r.dependencies {
+		r.watcher.Add(d)
+	}
+
+	timeoutCh := time.After(timeout)
+	var optionalCh <-chan time.Time
+	if r.hasOptional() {
+		optionalCh = time.After(config.TimeDurationVal(r.config.OptionalTimeout))
+	}
+
+	for !r.hasData() {
+		select {
+		case data := <-r.watcher.DataCh():
+			r.Receive(data.Dependency(), data.Data())
+		case err := <-r.watcher.ServerErrCh():
+			r.recordRetryableError(err)
+		case err := <-r.watcher.ErrCh():
+			if d := r.recordError(err); d == nil || !r.optional(d) {
+				return err
+			}
+		case <-optionalCh:
+			optionalCh = nil
+			r.expireOptional()
+		case <-timeoutCh:
+			return &ErrStartupTimeout{Timeout: timeout, Missing: r.missingDependencies()}
+		}
+	}
+	return nil
+}
+
+// hasData returns true if every dependency has data, or counts as empty.
+func (r *Runner) hasData() bool {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	for _, d := range r.dependencies {
+		if _, ok := r.data[d.String()]; !ok && !r.optionalEmpty(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// explain returns the variables set by envconsul which reach the child
+// process, sorted by key, in the same way as they are merged by a run.
+func (r *Runner) explain() ([]*explainedVar, error) {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	env, depEnv, ok, err := r.buildEnv()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("missing data for some dependencies")
+	}
+
+	// Replay the merge, remembering every value of each key.
+	values := make(map[string][]*explainedValue)
+	if !config.BoolVal(r.config.Pristine) {
+		for _, v := range os.Environ() {
+			list := strings.SplitN(v, "=", 2)
+			values[list[0]] = append(values[list[0]], &explainedValue{
+				Source: explainSourceParent,
+				Value:  list[1],
+			})
+		}
+	}
+	for _, d := range r.dependencies {
+		_, secret := d.(*dep.VaultReadQuery)
+		for k, v := range depEnv[d.String()] {
+			values[k] = append(values[k], &explainedValue{
+				Source: d.String(),
+				Value:  v,
+				secret: secret,
+			})
+		}
+	}
+
+	final := make(map[string]string, len(env))
+	for k, v := range env {
+		final[k] = v
+	}
+	final = r.applyConfigEnv(final)
+	for _, v := range r.config.Exec.Env.Custom {
+		list := strings.SplitN(v, "=", 2)
+		values[list[0]] = append(values[list[0]], &explainedValue{
+			Source: auditSourceExec,
+			Value:  list[1],
+		})
+	}
+
+	// Only the variables set by envconsul are explained, not the whole parent
+	// environment.
+	explained := make(map[string]string, len(final))
+	for k, v := range final {
+		if list := values[k]; len(list) > 0 && list[len(list)-1].Source != explainSourceParent {
+			explained[k] = v
+		}
+	}
+	origins := r.keyOrigins(depEnv, explained)
+
+	vars := make([]*explainedVar, 0, len(explained))
+	for _, k := range sortedKeys(explained) {
+		list := values[k]
+		vars = append(vars, &explainedVar{
+			Key:       k,
+			Value:     explained[k],
+			Origin:    origins[k],
+			Overrides: list[:len(list)-1],
+		})
+	}
+	return vars, nil
+}
+
+// writeExplanation writes the explained variables in a human readable form,
+// with the values of secrets and sensitive keys redacted.
+func (r *Runner) writeExplanation(w io.Writer, vars []*explainedVar) error {
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s=%q\n", v.Key, r.redactor.value(v.Key, v.Value, v.Origin.secret))
+
+		source := v.Origin.Source
+		if d := r.dependency(source); d != nil {
+			typ, path := r.source(d)
+			source = fmt.Sprintf("%s %q (%s)", typ, path, source)
+		}
+		fmt.Fprintf(&b, "  source:     %s\n", source)
+
+		key := v.Origin.RawKey
+		switch {
+		case v.Origin.ModifyIndex != 0:
+			key += fmt.Sprintf(" (modify index %d)", v.Origin.ModifyIndex)
+		case v.Origin.Version != 0:
+			key += fmt.Sprintf(" (version %d)", v.Origin.Version)
+		}
+		if key != "" {
+			fmt.Fprintf(&b, "  key:        %s\n", key)
+		}
+
+		for i, t := range v.Origin.Transforms {
+			label := ""
+			if i == 0 {
+				label = "transforms:"
+			}
+			fmt.Fprintf(&b, "  %-11s %s\n", label, t)
+		}
+
+		for i, o := range v.Overrides {
+			label := ""
+			if i == 0 {
+				label = "overrides:"
+			}
+			fmt.Fprintf(&b, "  %-11s %s = %q\n", label, o.Source,
+				r.redactor.value(v.Key, o.Value, o.secret))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dependency returns the dependency with the given string, or nil.
+func (r *Runner) dependency(id string) dep.Dependency {
+	for _, d := range r.dependencies {
+		if d.String() == id {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRunner_explain(t *testing.T) {
+	t.Setenv("DB_HOST", "parent.local")
+	t.Setenv("EXPLAIN_UNRELATED", "1")
+
+	c := DefaultConfig().Merge(&Config{
+		Sanitize: config.Bool(true),
+		Upcase:   config.Bool(true),
+		Exec: &config.ExecConfig{
+			Env: &config.EnvConfig{
+				Custom: []string{"APP_PORT=9000"},
+			},
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("shared")},
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+		},
+	})
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{
+		{Key: "db-host", Value: "shared.local", ModifyIndex: 3},
+	})
+	r.Receive(r.dependencies[1], []*dep.KeyPair{
+		{Key: "db-host", Value: "app.local", ModifyIndex: 7},
+		{Key: "app-port", Value: "8080", ModifyIndex: 8},
+	})
+	r.Receive(r.dependencies[2], &dep.Secret{
+		Data: map[string]interface{}{"password": "hunter2"},
+	})
+
+	vars, err := r.explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := r.writeExplanation(&b, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `APP_PORT="9000"
+  source:     exec.env
+  key:        APP_PORT
+  overrides:  kv.list(app) = "8080"
+DB_HOST="app.local"
+  source:     prefix "app" (kv.list(app))
+  key:        db-host (modify index 7)
+  transforms: sanitize: db-host -> db_host
+              upcase: db_host -> DB_HOST
+  overrides:  parent environment = "parent.local"
+              kv.list(shared) = "shared.local"
+PASSWORD="[redacted]"
+  source:     secret "secret/app" (vault.read(secret/app))
+  key:        password
+  transforms: upcase: password -> PASSWORD
+`
+	if b.String() != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, b.String())
+	}
+}
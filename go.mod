@@ -10,6 +10,9 @@ require (
 	github.com/hashicorp/hcl v1.0.1-vault-7
 	github.com/mitchellh/mapstructure v1.5.0
 	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.40.0
+	golang.org/x/sys v0.34.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -51,11 +54,8 @@ require (
 	github.com/ryanuber/go-glob v1.0.0 // indirect
 	github.com/shopspring/decimal v1.4.0 // indirect
 	github.com/spf13/cast v1.7.0 // indirect
-	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/exp v0.0.0-20250711185948-6ae5c78190dc // indirect
 	golang.org/x/net v0.42.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
 	golang.org/x/time v0.12.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
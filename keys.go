@@ -0,0 +1,61 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// ErrMissingKeys is returned when keys marked as required are missing from a
+// prefix. Until they are added, the environment is not built, so the child
+// process is not started, or keeps its current environment.
+type ErrMissingKeys struct {
+	// Dependency is the string of the prefix, such as kv.list(foo).
+	Dependency string
+	Keys       []string
+}
+
+func (e *ErrMissingKeys) Error() string {
+	return fmt.Sprintf("%s: missing required keys: %s", e.Dependency,
+		strings.Join(e.Keys, ", "))
+}
+
+// perKeyFormats returns the key blocks of a prefix or secret by name, and
+// whether they apply. They do not apply when there are none, or when the
+// prefix or secret has a format of its own.
+func perKeyFormats(cp *PrefixConfig) (map[string][]*KeyFormat, bool) {
+	if cp.Keys == nil || config.StringPresent(cp.Format) {
+		return nil, false
+	}
+
+	// pre-populate key formats map here so we don't have a potential O(n^2) complexity in the loop later
+	keyFormats := make(map[string][]*KeyFormat)
+	for _, v := range *cp.Keys {
+		name := config.StringVal(v.Name)
+		keyFormats[name] = append(keyFormats[name], v)
+	}
+	return keyFormats, true
+}
+
+// applyKeyFormats returns the keys a key is renamed to by its key blocks, one
+// for each block with a format, or the key itself when none has a format.
+func applyKeyFormats(formats []*KeyFormat, path, key string) ([]string, error) {
+	keys := []string{}
+	for _, format := range formats {
+		if config.StringPresent(format.Format) {
+			formatted, err := applyFormatTemplate(config.StringVal(format.Format), path, key)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, formatted)
+		}
+	}
+	if len(keys) == 0 {
+		return []string{key}, nil
+	}
+	return keys, nil
+}
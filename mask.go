@@ -0,0 +1,201 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+const (
+	// maskMarker replaces secret values in the output of the child process.
+	maskMarker = "****"
+
+	// maskMinLength is the length of the shortest value which is masked.
+	// Shorter values, like "1" or "true", would mask unrelated output.
+	maskMinLength = 4
+)
+
+// maskWriter is a writer which replaces secret values with maskMarker before
+// writing to the underlying writer. Values split across writes are masked by
+// holding back the end of a write when it is the start of a value, until the
+// next write tells whether it is, or until Flush when the child process exits.
+// At most the length of the longest value minus one byte is held back.
+type maskWriter struct {
+	sync.Mutex
+
+	w io.Writer
+
+	// values are the values to mask, longest first, so the longest match
+	// wins.
+	values [][]byte
+
+	// held is output which is the start of a value, waiting for the next
+	// write.
+	held []byte
+}
+
+// outputMask masks the standard out and standard error of one child process,
+// so the output of two child processes running side by side is never mixed.
+type outputMask struct {
+	out, err *maskWriter
+}
+
+// newOutputMask returns an outputMask writing to out and err, which masks the
+// given values.
+func newOutputMask(out, err io.Writer, values []string) *outputMask {
+	m := &outputMask{out: newMaskWriter(out), err: newMaskWriter(err)}
+	m.setValues(values)
+	return m
+}
+
+// setValues replaces the values which are masked.
+func (m *outputMask) setValues(values []string) {
+	if m == nil {
+		return
+	}
+	m.out.setValues(values)
+	m.err.setValues(values)
+}
+
+// Flush writes any held back output as it is.
+func (m *outputMask) Flush() {
+	if m == nil {
+		return
+	}
+	m.out.Flush()
+	m.err.Flush()
+}
+
+// newMaskWriter returns a maskWriter writing to w, which masks nothing until
+// values are set.
+func newMaskWriter(w io.Writer) *maskWriter {
+	return &maskWriter{w: w}
+}
+
+// setValues replaces the values which are masked.
+func (m *maskWriter) setValues(values []string) {
+	m.Lock()
+	defer m.Unlock()
+
+	seen := make(map[string]bool, len(values))
+	m.values = m.values[:0]
+	for _, v := range values {
+		if len(v) < maskMinLength || seen[v] {
+			continue
+		}
+		seen[v] = true
+		m.values = append(m.values, []byte(v))
+	}
+	sort.Slice(m.values, func(i, j int) bool {
+		return len(m.values[i]) > len(m.values[j])
+	})
+
+	// Held back output may no longer be the start of a value.
+	if len(m.held) > 0 {
+		data := m.held
+		m.held = nil
+		m.w.Write(m.mask(data))
+	}
+}
+
+// Write implements io.Writer.
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	data := append(m.held, p...)
+	m.held = nil
+	if _, err := m.w.Write(m.mask(data)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// mask returns data with the values masked, except for the end of data when
+// it is the start of a value, which is held back. The caller must hold the
+// lock.
+func (m *maskWriter) mask(data []byte) []byte {
+	var out bytes.Buffer
+	i := 0
+	for i < len(data) {
+		if n := m.match(data[i:]); n > 0 {
+			out.WriteString(maskMarker)
+			i += n
+			continue
+		}
+		if len(m.values) > 0 && len(data)-i < len(m.values[0]) && m.partial(data[i:]) {
+			m.held = append([]byte{}, data[i:]...)
+			break
+		}
+		out.WriteByte(data[i])
+		i++
+	}
+	return out.Bytes()
+}
+
+// match returns the length of the value that data starts with, or 0.
+func (m *maskWriter) match(data []byte) int {
+	for _, v := range m.values {
+		if bytes.HasPrefix(data, v) {
+			return len(v)
+		}
+	}
+	return 0
+}
+
+// partial returns true if all of data is the start of a value.
+func (m *maskWriter) partial(data []byte) bool {
+	for _, v := range m.values {
+		if len(data) < len(v) && bytes.HasPrefix(v, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush writes any held back output as it is, once the child process is done
+// writing.
+func (m *maskWriter) Flush() {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.held) > 0 {
+		m.w.Write(m.held)
+		m.held = nil
+	}
+}
+
+// setMaskValues updates the values masked in the output of the current child
+// process to the current values of secrets, including those written to files.
+func (r *Runner) setMaskValues(env map[string]string) {
+	r.mask.setValues(secretValues(env, r.secretKeys, r.secretFiles))
+}
+
+// newChildMask returns the outputMask for a new child process, masking the
+// current values of secrets, or nil when masking is disabled.
+func (r *Runner) newChildMask() *outputMask {
+	if !config.BoolVal(r.config.MaskOutput) {
+		return nil
+	}
+	return newOutputMask(r.outStream, r.errStream,
+		secretValues(r.env, r.secretKeys, r.secretFiles))
+}
+
+// secretValues returns the values of the secret keys of env and the contents
+// of the secret files.
+func secretValues(env map[string]string, keys map[string]bool, files map[string]*secretFile) []string {
+	values := make([]string, 0, len(keys)+len(files))
+	for k := range keys {
+		values = append(values, env[k])
+	}
+	for _, f := range files {
+		values = append(values, string(f.contents))
+	}
+	return values
+}
@@ -0,0 +1,259 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// syncBuffer is a bytes.Buffer which is safe to write from the child process
+// goroutines while being read by the test.
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestMaskWriter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		values []string
+		writes []string
+		exp    string
+	}{
+		{
+			"none",
+			nil,
+			[]string{"hello hunter2\n"},
+			"hello hunter2\n",
+		},
+		{
+			"single_write",
+			[]string{"hunter2"},
+			[]string{"password=hunter2, again hunter2\n"},
+			"password=****, again ****\n",
+		},
+		{
+			"split_writes",
+			[]string{"hunter2"},
+			[]string{"password=hun", "te", "r2\n"},
+			"password=****\n",
+		},
+		{
+			"split_no_match",
+			[]string{"hunter2"},
+			[]string{"hunt", "ing\n"},
+			"hunting\n",
+		},
+		{
+			"longest_first",
+			[]string{"abcd", "abcdefgh"},
+			[]string{"x=abcdefgh y=abcd\n"},
+			"x=**** y=****\n",
+		},
+		{
+			"short_values",
+			[]string{"1", "true"},
+			[]string{"debug=true port=1\n"},
+			"debug=**** port=1\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m := newMaskWriter(&buf)
+			m.setValues(tc.values)
+			for _, w := range tc.writes {
+				n, err := m.Write([]byte(w))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != len(w) {
+					t.Fatalf("expected %d bytes written, got %d", len(w), n)
+				}
+			}
+			m.Flush()
+			if buf.String() != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, buf.String())
+			}
+		})
+	}
+
+	t.Run("held_output_waits_for_write", func(t *testing.T) {
+		var buf bytes.Buffer
+		m := newMaskWriter(&buf)
+		m.setValues([]string{"hunter2"})
+		if _, err := m.Write([]byte("prompt> hun")); err != nil {
+			t.Fatal(err)
+		}
+
+		// A slow write of the rest of the value is still masked.
+		time.Sleep(200 * time.Millisecond)
+		if buf.String() != "prompt> " {
+			t.Fatalf("expected the start of the value to be held, got %q", buf.String())
+		}
+		if _, err := m.Write([]byte("ter2\n")); err != nil {
+			t.Fatal(err)
+		}
+		if exp := "prompt> ****\n"; buf.String() != exp {
+			t.Errorf("expected %q, got %q", exp, buf.String())
+		}
+	})
+
+	t.Run("held_output_is_flushed", func(t *testing.T) {
+		var buf bytes.Buffer
+		m := newMaskWriter(&buf)
+		m.setValues([]string{"hunter2"})
+		if _, err := m.Write([]byte("prompt> hun")); err != nil {
+			t.Fatal(err)
+		}
+		m.Flush()
+		if exp := "prompt> hun"; buf.String() != exp {
+			t.Errorf("expected %q, got %q", exp, buf.String())
+		}
+	})
+
+	t.Run("held_output_is_released_by_new_values", func(t *testing.T) {
+		var buf bytes.Buffer
+		m := newMaskWriter(&buf)
+		m.setValues([]string{"hunter2"})
+		if _, err := m.Write([]byte("prompt> hun")); err != nil {
+			t.Fatal(err)
+		}
+		m.setValues([]string{"password"})
+		if exp := "prompt> hun"; buf.String() != exp {
+			t.Errorf("expected %q, got %q", exp, buf.String())
+		}
+	})
+
+	t.Run("separate_children", func(t *testing.T) {
+		var buf bytes.Buffer
+		prev := newOutputMask(&buf, &buf, []string{"hunter2"})
+		next := newOutputMask(&buf, &buf, []string{"hunter3"})
+		prev.out.Write([]byte("prev: hun"))
+		next.out.Write([]byte("next: ok\n"))
+		prev.out.Write([]byte("ter2\n"))
+		if exp := "prev: next: ok\n****\n"; buf.String() != exp {
+			t.Errorf("expected %q, got %q", exp, buf.String())
+		}
+	})
+
+	t.Run("update_values", func(t *testing.T) {
+		var buf bytes.Buffer
+		m := newMaskWriter(&buf)
+		m.setValues([]string{"old-secret"})
+		m.Write([]byte("old-secret new-secret\n"))
+		m.setValues([]string{"new-secret"})
+		m.Write([]byte("old-secret new-secret\n"))
+		exp := "**** new-secret\nold-secret ****\n"
+		if buf.String() != exp {
+			t.Errorf("expected %q, got %q", exp, buf.String())
+		}
+	})
+}
+
+func TestRunner_stopChild_maskFlush(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		MaskOutput: config.Bool(true),
+		Exec: &config.ExecConfig{
+			Command:     []string{"sh", "-c", `printf "password> ${password%???}"; while :; do sleep 0.05; done`},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	var out syncBuffer
+	r.outStream = &out
+
+	r.Receive(r.dependencies[0], &dep.Secret{
+		Data: map[string]interface{}{"password": "hunter2"},
+	})
+	if _, err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The start of the secret is held back until the child process exits.
+	time.Sleep(200 * time.Millisecond)
+	if exp := "password> "; out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+	r.stopChild()
+	if exp := "password> hunt"; out.String() != exp {
+		t.Errorf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestRunner_Run_maskOutput(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		MaskOutput: config.Bool(true),
+		Exec: &config.ExecConfig{
+			Command: []string{"sh", "-c", `echo "host=$host password=$password"`},
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	var out syncBuffer
+	r.outStream = &out
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "host", Value: "db.local"}})
+	r.Receive(r.dependencies[1], &dep.Secret{
+		Data: map[string]interface{}{"password": "hunter2"},
+	})
+	exitCh, err := r.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-exitCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("child did not exit")
+	}
+	r.mask.Flush()
+
+	if exp := "host=db.local password=****\n"; out.String() != exp {
+		t.Errorf("expected %q, got %q", exp, out.String())
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notifyEnv are the variables set by the service manager for Envconsul, which
+// are not passed on to the child process.
+var notifyEnv = []string{"NOTIFY_SOCKET", "WATCHDOG_PID", "WATCHDOG_USEC"}
+
+// notifier sends state changes to the service manager using the systemd
+// sd_notify protocol, so that Envconsul can run as a Type=notify unit. A nil
+// notifier does nothing.
+type notifier struct {
+	addr *net.UnixAddr
+}
+
+// newNotifier returns a notifier for the given socket, as in NOTIFY_SOCKET,
+// or nil if it is empty. A socket starting with "@" is in the abstract
+// namespace, which the net package handles.
+func newNotifier(socket string) *notifier {
+	if socket == "" {
+		return nil
+	}
+	return &notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// newNotifierFromEnv returns a notifier for the socket given by the service
+// manager, or nil when not run by one.
+func newNotifierFromEnv() *notifier {
+	return newNotifier(os.Getenv("NOTIFY_SOCKET"))
+}
+
+// notify sends the given state lines, such as "READY=1". Failures are only
+// logged, since they must not stop the child process.
+func (n *notifier) notify(states ...string) {
+	if n == nil {
+		return
+	}
+	logger := namedLogger("notify")
+
+	conn, err := net.DialUnix(n.addr.Net, nil, n.addr)
+	if err != nil {
+		logger.Warn("could not connect to the notify socket:", err)
+		return
+	}
+	defer conn.Close()
+
+	logger.Trace("sending", strings.Join(states, ", "))
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		logger.Warn("could not send to the notify socket:", err)
+	}
+}
+
+// ready tells the service manager that Envconsul has started, or finished
+// reloading, along with a status line.
+func (n *notifier) ready(status string) {
+	n.notify("READY=1", "STATUS="+status)
+}
+
+// reloading tells the service manager that Envconsul is reloading its
+// configuration. It is followed by ready once the new configuration runs. The
+// time of the reload on the monotonic clock is required by Type=notify-reload
+// units.
+func (n *notifier) reloading() {
+	if n == nil {
+		return
+	}
+	usec, err := monotonicUsec()
+	if err != nil {
+		namedLogger("notify").Warn("could not read the monotonic clock:", err)
+		n.notify("RELOADING=1", "STATUS=Reloading configuration")
+		return
+	}
+	n.notify("RELOADING=1", "MONOTONIC_USEC="+strconv.FormatInt(usec, 10),
+		"STATUS=Reloading configuration")
+}
+
+// status sends a status line describing the current state.
+func (n *notifier) status(status string) {
+	n.notify("STATUS=" + status)
+}
+
+// watchdog sends a keep-alive ping to the service manager watchdog.
+func (n *notifier) watchdog() {
+	n.notify("WATCHDOG=1")
+}
+
+// watchdogInterval returns how often to ping the service manager watchdog,
+// which is half the timeout given in WATCHDOG_USEC, or zero when the watchdog
+// is disabled or meant for another process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifyStatus returns a status line describing the sources of the
+// environment: the ones still missing data, or the ones the environment was
+// built from.
+func (r *Runner) notifyStatus() string {
+	if missing := r.missingDependencies(); len(missing) > 0 {
+		sources := make([]string, 0, len(missing))
+		for _, m := range missing {
+			sources = append(sources, fmt.Sprintf("%s %q", m.Type, m.Path))
+		}
+		return fmt.Sprintf("Waiting for data from %d of %d sources: %s",
+			len(missing), len(r.dependencies), strings.Join(sources, ", "))
+	}
+
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	if r.filteredEnv == nil {
+		return "Waiting for the environment to be applied"
+	}
+	if len(r.dependencies) == 0 {
+		return fmt.Sprintf("Running with %d variables", len(r.filteredEnv))
+	}
+	sources := make([]string, 0, len(r.dependencies))
+	for _, d := range r.dependencies {
+		typ, path := r.source(d)
+		sources = append(sources, fmt.Sprintf("%s %q", typ, path))
+	}
+	return fmt.Sprintf("Running with %d variables from %d sources: %s",
+		len(r.filteredEnv), len(r.dependencies), strings.Join(sources, ", "))
+}
+
+// notifyRun tells the service manager about the state after a run. The first
+// run which applies an environment makes Envconsul ready.
+func (r *Runner) notifyRun() {
+	if r.notifier == nil {
+		return
+	}
+	status := r.notifyStatus()
+	if !r.notifiedReady && r.filteredEnv != nil {
+		r.notifiedReady = true
+		r.notifier.ready(status)
+		return
+	}
+	r.notifier.status(status)
+}
+
+// notifyReloading tells the service manager that the configuration of the
+// runner is being reloaded.
+func (r *Runner) notifyReloading() {
+	r.notifier.reloading()
+}
+
+// runWatchdog pings the service manager watchdog, when enabled, until the
+// runner is stopped. The watchdog is only pinged while the watchers are
+// healthy, so that the service manager can restart Envconsul when they are
+// not.
+func (r *Runner) runWatchdog() {
+	interval := watchdogInterval()
+	if interval <= 0 || r.notifier == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if r.watchersHealthy() {
+				r.notifier.watchdog()
+			} else {
+				namedLogger("notify").Debug("not pinging the watchdog, a watcher is failing")
+			}
+		case <-r.DoneCh:
+			return
+		}
+	}
+}
+
+// watchersHealthy returns true if no dependency is failing. Missing required
+// keys are reported by the data itself, so they do not count, and neither do
+// retryable errors once the retry is due without another error.
+func (r *Runner) watchersHealthy() bool {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	r.clearRecovered()
+	for _, err := range r.depErrors {
+		if _, ok := err.(*ErrMissingKeys); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,16 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// monotonicUsec is only supported on linux, where the service manager runs.
+func monotonicUsec() (int64, error) {
+	return 0, errors.New("monotonic clock is not supported")
+}
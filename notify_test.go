@@ -0,0 +1,280 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// testNotifySocket listens on a unix datagram socket, as the service manager
+// does, and returns its path.
+func testNotifySocket(t *testing.T) (string, *net.UnixConn) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return path, conn
+}
+
+// readNotify returns the next message sent to the socket.
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4096)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b[:n])
+}
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	path, conn := testNotifySocket(t)
+	n := newNotifier(path)
+
+	n.ready("Running")
+	if act, exp := readNotify(t, conn), "READY=1\nSTATUS=Running"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+	n.reloading()
+	if act := readNotify(t, conn); !regexp.MustCompile(
+		`^RELOADING=1\nMONOTONIC_USEC=[1-9][0-9]*\nSTATUS=Reloading configuration$`).MatchString(act) {
+		t.Errorf("unexpected reloading message %q", act)
+	}
+	n.watchdog()
+	if act, exp := readNotify(t, conn), "WATCHDOG=1"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+
+	// Without a socket, nothing is sent.
+	newNotifier("").ready("Running")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	cases := []struct {
+		name string
+		usec string
+		pid  string
+		exp  time.Duration
+	}{
+		{"disabled", "", "", 0},
+		{"half", "2000000", "", time.Second},
+		{"this_pid", "2000000", strconv.Itoa(os.Getpid()), time.Second},
+		{"other_pid", "2000000", "1", 0},
+		{"invalid", "soon", "", 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tc.usec)
+			t.Setenv("WATCHDOG_PID", tc.pid)
+			if act := watchdogInterval(); act != tc.exp {
+				t.Errorf("expected %s, got %s", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_notifyRun(t *testing.T) {
+	t.Parallel()
+
+	path, conn := testNotifySocket(t)
+	c := DefaultConfig().Merge(&Config{
+		Print: &PrintConfig{
+			Format: config.String(PrintFormatDotenv),
+			Path:   config.String(filepath.Join(t.TempDir(), "app.env")),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.notifier = newNotifier(path)
+
+	run := func() {
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		r.notifyRun()
+	}
+
+	run()
+	if act, exp := readNotify(t, conn), `STATUS=Waiting for data from 1 of 1 sources: prefix "app"`; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "PORT", Value: "80"}})
+	run()
+	if act, exp := readNotify(t, conn), "READY=1\nSTATUS=Running with 1 variables from 1 sources: prefix \"app\""; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+
+	// Ready is only sent once.
+	r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "PORT", Value: "80"}, {Key: "HOST", Value: "a"}})
+	run()
+	if act, exp := readNotify(t, conn), `STATUS=Running with 2 variables from 1 sources: prefix "app"`; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+}
+
+func TestRunner_Start_watchdog(t *testing.T) {
+	path, conn := testNotifySocket(t)
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	r, err := NewRunner(DefaultConfig(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Start()
+	defer r.Stop()
+
+	if act, exp := readNotify(t, conn), "WATCHDOG=1"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+
+	// The watchdog is not pinged while a watcher is failing.
+	r.dependenciesLock.Lock()
+	r.depErrors["kv.list(app)"] = errors.New("connection refused")
+	r.dependenciesLock.Unlock()
+	if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	// Drain a ping which may have been sent before the error was recorded.
+	b := make([]byte, 4096)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			break
+		}
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := conn.Read(b); err == nil {
+		t.Errorf("expected no ping, got %q", string(b[:n]))
+	}
+}
+
+func TestRunner_childEnvMap_notify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("ENVCONSUL_TEST_PARENT", "yes")
+
+	r, err := NewRunner(DefaultConfig(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := r.childEnvMap(map[string]string{"PORT": "80"})
+	for _, k := range notifyEnv {
+		if _, ok := env[k]; ok {
+			t.Errorf("expected %s not to be passed to the child", k)
+		}
+	}
+	if env["ENVCONSUL_TEST_PARENT"] != "yes" || env["PORT"] != "80" {
+		t.Errorf("expected the rest of the environment, got %v", env)
+	}
+}
+
+func TestRunner_runWatchdog_readiness(t *testing.T) {
+	path, conn := testNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	c := DefaultConfig().Merge(&Config{
+		Exec: &config.ExecConfig{
+			Command:     []string{"sh", "-c", "while :; do sleep 0.05; done"},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Readiness: &ReadinessConfig{
+			File:     config.String(filepath.Join(t.TempDir(), "never")),
+			Interval: config.TimeDuration(50 * time.Millisecond),
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.notifier = newNotifier(path)
+	defer r.Stop()
+
+	// The watchdog is still pinged while the run waits for the child process
+	// to become ready.
+	go r.Run()
+	go r.runWatchdog()
+	if act, exp := readNotify(t, conn), "WATCHDOG=1"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+}
+
+func TestRunner_runWatchdog_retry(t *testing.T) {
+	path, conn := testNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	c := DefaultConfig().Merge(&Config{
+		Consul: &config.ConsulConfig{
+			Retry: &config.RetryConfig{
+				Backoff:    config.TimeDuration(10 * time.Millisecond),
+				MaxBackoff: config.TimeDuration(10 * time.Millisecond),
+			},
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.notifier = newNotifier(path)
+	r.retryGrace = 200 * time.Millisecond
+	defer r.Stop()
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "HOST", Value: "a"}})
+
+	// A retryable error stops the pings until its retry is due. The watcher
+	// then reaches the server again, but does not resend the unchanged data.
+	start := time.Now()
+	r.recordRetryableError(errors.New("kv.list(app): connection refused"))
+	if r.watchersHealthy() {
+		t.Fatal("expected the watchers not to be healthy after an error")
+	}
+
+	go r.runWatchdog()
+	if act, exp := readNotify(t, conn), "WATCHDOG=1"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+	if elapsed := time.Since(start); elapsed < r.retryGrace {
+		t.Errorf("expected no ping while the error is retried, got one after %s", elapsed)
+	}
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+	if _, ok := r.depErrors["kv.list(app)"]; ok {
+		t.Error("expected the error to be cleared")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// optional returns true if the dependency is an optional prefix, secret or
+// service.
+func (r *Runner) optional(d dep.Dependency) bool {
+	if cp, ok := r.configPrefixMap[d.String()]; ok {
+		return config.BoolVal(cp.Optional)
+	}
+	if cs, ok := r.configServiceMap[d.String()]; ok {
+		return config.BoolVal(cs.Optional)
+	}
+	return false
+}
+
+// hasOptional returns true if any of the dependencies is optional.
+func (r *Runner) hasOptional() bool {
+	for _, d := range r.dependencies {
+		if r.optional(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalEmpty returns true if the optional dependency without data counts
+// as empty, because its watcher gave up with an error or the optional timeout
+// was reached. Errors which are retried do not count, the watcher may still
+// return data. The caller must hold the dependencies lock.
+func (r *Runner) optionalEmpty(d dep.Dependency) bool {
+	if !r.optional(d) {
+		return false
+	}
+	_, retried := r.depRetries[d.String()]
+	return r.optionalExpired || (r.depErrors[d.String()] != nil && !retried)
+}
+
+// expireOptional makes optional dependencies which have not returned data
+// count as empty.
+func (r *Runner) expireOptional() {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+	r.optionalExpired = true
+}
+
+// logOptional logs whether the optional dependency is supplying data, when
+// that changes. The caller must hold the dependencies lock.
+func (r *Runner) logOptional(d dep.Dependency, supplying bool) {
+	if prev, ok := r.optionalSupplying[d.String()]; ok && prev == supplying {
+		return
+	}
+	r.optionalSupplying[d.String()] = supplying
+
+	logger := namedLogger("runner")
+	typ, path := r.source(d)
+	if supplying {
+		logger.Info(fmt.Sprintf("optional %s %q is supplying data", typ, path))
+		return
+	}
+
+	reason := "no data within the optional timeout"
+	if err := r.depErrors[d.String()]; err != nil {
+		reason = err.Error()
+	}
+	logger.Warn(fmt.Sprintf("optional %s %q is not supplying data, "+
+		"continuing without it: %s", typ, path, reason))
+}
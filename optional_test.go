@@ -0,0 +1,111 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/pkg/errors"
+)
+
+func TestRunner_Run_optional(t *testing.T) {
+	t.Parallel()
+
+	newRunner := func(t *testing.T) (*Runner, *bytes.Buffer) {
+		c := DefaultConfig().Merge(&Config{
+			Print: &PrintConfig{
+				Format: config.String(PrintFormatDotenv),
+			},
+			Prefixes: &PrefixConfigs{
+				&PrefixConfig{Path: config.String("app")},
+				&PrefixConfig{Path: config.String("extra"), Optional: config.Bool(true)},
+			},
+		})
+		r, err := NewRunner(c, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		r.outStream = &buf
+		r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "HOST", Value: "a"}})
+		return r, &buf
+	}
+
+	t.Run("error", func(t *testing.T) {
+		r, buf := newRunner(t)
+
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("expected to wait for the optional prefix, got %q", buf.String())
+		}
+
+		r.recordError(errors.Wrap(fmt.Errorf("connection refused"), "kv.list(extra)"))
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "HOST=a\n" {
+			t.Fatalf("bad output: %q", buf.String())
+		}
+		if r.optionalSupplying["kv.list(extra)"] {
+			t.Error("expected optional prefix not to be supplying data")
+		}
+
+		buf.Reset()
+		r.Receive(r.dependencies[1], []*dep.KeyPair{{Key: "PORT", Value: "80"}})
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "HOST=a\nPORT=80\n" {
+			t.Fatalf("bad output: %q", buf.String())
+		}
+		if !r.optionalSupplying["kv.list(extra)"] {
+			t.Error("expected optional prefix to be supplying data")
+		}
+	})
+
+	t.Run("retryable_error", func(t *testing.T) {
+		r, buf := newRunner(t)
+
+		// The watcher still retries, so the optional prefix may return data.
+		r.recordRetryableError(errors.Wrap(fmt.Errorf("connection refused"), "kv.list(extra)"))
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("expected to wait for the optional prefix, got %q", buf.String())
+		}
+
+		r.Receive(r.dependencies[1], []*dep.KeyPair{{Key: "PORT", Value: "80"}})
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "HOST=a\nPORT=80\n" {
+			t.Fatalf("bad output: %q", buf.String())
+		}
+		if _, ok := r.depErrors["kv.list(extra)"]; ok {
+			t.Error("expected the error to be cleared")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		r, buf := newRunner(t)
+
+		r.expireOptional()
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "HOST=a\n" {
+			t.Fatalf("bad output: %q", buf.String())
+		}
+		if missing := r.missingDependencies(); len(missing) != 0 {
+			t.Errorf("expected optional prefix not to be missing, got %v", missing)
+		}
+	})
+}
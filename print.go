@@ -0,0 +1,139 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// PrintFormatDotenv prints KEY=value lines as understood by most dotenv
+	// loaders. Values are double quoted when needed, with newlines escaped.
+	PrintFormatDotenv = "dotenv"
+
+	// PrintFormatJSON prints a single JSON object of keys to values.
+	PrintFormatJSON = "json"
+
+	// PrintFormatExport prints POSIX shell "export KEY='value'" statements that
+	// can be sourced or eval'd.
+	PrintFormatExport = "export"
+
+	// PrintFormatSystemd prints a file suitable for systemd's EnvironmentFile=
+	// directive.
+	PrintFormatSystemd = "systemd"
+)
+
+// PrintFormats is the list of all supported print formats.
+var PrintFormats = []string{
+	PrintFormatDotenv,
+	PrintFormatJSON,
+	PrintFormatExport,
+	PrintFormatSystemd,
+}
+
+// shellNameRegexp matches keys which are valid shell variable names.
+var shellNameRegexp = regexp.MustCompile(`\A[a-zA-Z_][a-zA-Z0-9_]*\z`)
+
+// bareValueRegexp matches values which are safe to print without quotes.
+var bareValueRegexp = regexp.MustCompile(`\A[a-zA-Z0-9_@%+=:,./-]+\z`)
+
+// validPrintFormat returns true if the given format is a known print format.
+func validPrintFormat(s string) bool {
+	for _, f := range PrintFormats {
+		if s == f {
+			return true
+		}
+	}
+	return false
+}
+
+// formatEnv renders the environment in the given format. Keys are sorted so
+// that the output is stable between runs.
+func formatEnv(format string, env map[string]string) ([]byte, error) {
+	if format == PrintFormatJSON {
+		b, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	logger := namedLogger("print")
+	var buf bytes.Buffer
+	for _, k := range keys {
+		v := env[k]
+		switch format {
+		case PrintFormatDotenv:
+			fmt.Fprintf(&buf, "%s=%s\n", k, quoteDotenv(v))
+		case PrintFormatExport:
+			// A key which is not a valid name would make the whole script fail
+			// to evaluate, so skip it instead.
+			if !shellNameRegexp.MatchString(k) {
+				logger.Warn(fmt.Sprintf("skipping key %q, not a valid shell variable name", k))
+				continue
+			}
+			fmt.Fprintf(&buf, "export %s=%s\n", k, quoteShell(v))
+		case PrintFormatSystemd:
+			fmt.Fprintf(&buf, "%s=%s\n", k, quoteSystemd(v))
+		default:
+			return nil, fmt.Errorf("unknown print format %q", format)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// quoteDotenv double quotes the value if it contains anything other than
+// plain characters. Newlines are written as \n, which dotenv loaders expand
+// inside double quotes.
+func quoteDotenv(v string) string {
+	if bareValueRegexp.MatchString(v) {
+		return v
+	}
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+		"\r", `\r`,
+	)
+	return `"` + r.Replace(v) + `"`
+}
+
+// quoteShell single quotes the value for a POSIX shell. Single quotes preserve
+// everything literally, including newlines, so only embedded single quotes
+// need escaping.
+func quoteShell(v string) string {
+	if bareValueRegexp.MatchString(v) {
+		return v
+	}
+	return `'` + strings.ReplaceAll(v, `'`, `'\''`) + `'`
+}
+
+// quoteSystemd double quotes the value for systemd's EnvironmentFile=.
+// systemd keeps newlines inside double quotes and only treats backslash,
+// double quote, dollar and backtick specially.
+func quoteSystemd(v string) string {
+	if bareValueRegexp.MatchString(v) {
+		return v
+	}
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"`", "\\`",
+	)
+	return `"` + r.Replace(v) + `"`
+}
@@ -0,0 +1,145 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestFormatEnv(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"PLAIN":     "value",
+		"SPACES":    "hello world",
+		"MULTILINE": "line1\nline2",
+		"SPECIAL":   `it's "$HOME"`,
+		"EMPTY":     "",
+		"not-valid": "x",
+	}
+
+	cases := []struct {
+		name   string
+		format string
+		exp    string
+	}{
+		{
+			"dotenv",
+			PrintFormatDotenv,
+			`EMPTY=""
+MULTILINE="line1\nline2"
+PLAIN=value
+SPACES="hello world"
+SPECIAL="it's \"\$HOME\""
+not-valid=x
+`,
+		},
+		{
+			"export",
+			PrintFormatExport,
+			`export EMPTY=''
+export MULTILINE='line1
+line2'
+export PLAIN=value
+export SPACES='hello world'
+export SPECIAL='it'\''s "$HOME"'
+`,
+		},
+		{
+			"systemd",
+			PrintFormatSystemd,
+			`EMPTY=""
+MULTILINE="line1
+line2"
+PLAIN=value
+SPACES="hello world"
+SPECIAL="it's \"\$HOME\""
+not-valid=x
+`,
+		},
+		{
+			"json",
+			PrintFormatJSON,
+			`{
+  "EMPTY": "",
+  "MULTILINE": "line1\nline2",
+  "PLAIN": "value",
+  "SPACES": "hello world",
+  "SPECIAL": "it's \"$HOME\"",
+  "not-valid": "x"
+}
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := formatEnv(tc.format, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.exp {
+				t.Errorf("\nexp: %q\nact: %q", tc.exp, string(out))
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := formatEnv("yaml", env); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestRunner_printEnv(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "app.env")
+
+	c := DefaultConfig().Merge(&Config{
+		Print: &PrintConfig{
+			Format: config.String(PrintFormatDotenv),
+			Path:   config.String(path),
+		},
+	})
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.printEnv(map[string]string{"FOO": "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "FOO=bar\n" {
+		t.Errorf("bad contents: %q", string(b))
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != DefaultPrintPerms {
+		t.Errorf("bad perms: %s", stat.Mode())
+	}
+
+	var buf bytes.Buffer
+	r.config.Print.Path = config.String("")
+	r.outStream = &buf
+	if err := r.printEnv(map[string]string{"FOO": "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "FOO=bar\n" {
+		t.Errorf("bad output: %q", buf.String())
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-template/child"
+	"github.com/hashicorp/consul-template/config"
+)
+
+// ErrNotReady is returned in once mode when the readiness deadline is reached
+// before the child process becomes ready.
+type ErrNotReady struct {
+	Reason string
+}
+
+func (e *ErrNotReady) Error() string {
+	return "child process did not become ready: " + e.Reason
+}
+
+// ExitStatus implements the manager.ErrExitable interface.
+func (e *ErrNotReady) ExitStatus() int {
+	return ExitCodeNotReady
+}
+
+// probeReadiness runs the configured probe once, and returns an error if the
+// child process is not ready. env is the environment of the child process,
+// which the command probe is run with.
+func probeReadiness(c *ReadinessConfig, env []string) error {
+	timeout := config.TimeDurationVal(c.Timeout)
+
+	switch {
+	case config.StringPresent(c.HTTP):
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(config.StringVal(c.HTTP))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	case config.StringPresent(c.TCP):
+		conn, err := net.DialTimeout("tcp", config.StringVal(c.TCP), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case config.StringPresent(c.Command):
+		args, _, err := child.CommandPrep([]string{config.StringVal(c.Command)})
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = env
+		return cmd.Run()
+	case config.StringPresent(c.File):
+		_, err := os.Stat(config.StringVal(c.File))
+		return err
+	}
+	return nil
+}
+
+// readinessMonitor probes a child process at an interval, and logs when it
+// becomes ready and when it stops being ready.
+type readinessMonitor struct {
+	config  *ReadinessConfig
+	env     []string
+	started time.Time
+
+	// readyCh is closed the first time the child process is ready.
+	readyCh chan struct{}
+	stopCh  chan struct{}
+	stop    func()
+
+	// lastErr is the error of the last probe.
+	lastErr     error
+	lastErrLock sync.Mutex
+}
+
+// newReadinessMonitor starts probing a child process which was just started
+// with the given environment.
+func newReadinessMonitor(c *ReadinessConfig, env []string) *readinessMonitor {
+	m := &readinessMonitor{
+		config:  c,
+		env:     env,
+		started: time.Now(),
+		readyCh: make(chan struct{}),
+		stopCh:  make(chan struct{}),
+	}
+	m.stop = sync.OnceFunc(func() { close(m.stopCh) })
+	go m.run()
+	return m
+}
+
+func (m *readinessMonitor) run() {
+	logger := namedLogger("readiness")
+
+	ticker := time.NewTicker(config.TimeDurationVal(m.config.Interval))
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		err := probeReadiness(m.config, m.env)
+		m.lastErrLock.Lock()
+		m.lastErr = err
+		m.lastErrLock.Unlock()
+
+		switch {
+		case err == nil && !ready:
+			ready = true
+			select {
+			case <-m.readyCh:
+				logger.Info("child process is ready again")
+			default:
+				logger.Info(fmt.Sprintf("child process is ready after %s",
+					time.Since(m.started).Round(time.Millisecond)))
+				close(m.readyCh)
+			}
+		case err != nil && ready:
+			ready = false
+			logger.Warn("child process is no longer ready:", err)
+		case err != nil:
+			logger.Debug("child process is not ready yet:", err)
+		}
+
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// err returns the error of the last probe.
+func (m *readinessMonitor) err() error {
+	m.lastErrLock.Lock()
+	defer m.lastErrLock.Unlock()
+	return m.lastErr
+}
+
+// deadline returns a channel which receives when the deadline for the child
+// process to become ready is reached, which is nil without a deadline, and a
+// function to release it.
+func (m *readinessMonitor) deadline() (<-chan time.Time, func()) {
+	deadline := config.TimeDurationVal(m.config.Deadline)
+	if deadline <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(m.started.Add(deadline)))
+	return timer.C, func() { timer.Stop() }
+}
+
+// deadlineErr returns the error for a child process which did not become
+// ready before the deadline.
+func (m *readinessMonitor) deadlineErr() error {
+	return &ErrNotReady{Reason: fmt.Sprintf("deadline of %s reached, last probe "+
+		"failed: %s", config.TimeDurationVal(m.config.Deadline), m.err())}
+}
+
+// currentReadiness returns the monitor of the current child process, or nil.
+func (r *Runner) currentReadiness() *readinessMonitor {
+	r.childLock.RLock()
+	defer r.childLock.RUnlock()
+	return r.readiness
+}
+
+// watchReadiness starts probing the current child process, replacing the
+// monitor of the previous child.
+func (r *Runner) watchReadiness(m *readinessMonitor) {
+	r.childLock.Lock()
+	defer r.childLock.Unlock()
+
+	if r.readiness != nil {
+		r.readiness.stop()
+	}
+	r.readiness = m
+}
+
+// newReadiness returns a monitor for a child process which was just started
+// with the environment from the last run, or nil if readiness is not probed.
+func (r *Runner) newReadiness() *readinessMonitor {
+	if !r.config.Readiness.Enabled() {
+		return nil
+	}
+	return newReadinessMonitor(r.config.Readiness, r.childEnv)
+}
+
+// waitReady waits for the child process probed by the monitor to become
+// ready. It returns an *ErrNotReady if the deadline is reached first. If the
+// child process exits first, its exit code is sent again on the returned
+// channel, so that the exit is handled like any other. It also returns when
+// the runner is stopped.
+func (r *Runner) waitReady(m *readinessMonitor, exitCh <-chan int) (<-chan int, error) {
+	deadlineCh, stop := m.deadline()
+	defer stop()
+
+	select {
+	case <-m.readyCh:
+		return exitCh, nil
+	case code := <-exitCh:
+		namedLogger("readiness").Error(fmt.Sprintf("child process exited with "+
+			"code %d before becoming ready", code))
+		resendCh := make(chan int, 1)
+		resendCh <- code
+		return resendCh, nil
+	case <-deadlineCh:
+		return exitCh, m.deadlineErr()
+	case <-r.DoneCh:
+		return exitCh, nil
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestProbeReadiness(t *testing.T) {
+	t.Parallel()
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ready")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		c    *ReadinessConfig
+		err  bool
+	}{
+		{"http", &ReadinessConfig{HTTP: config.String(ok.URL)}, false},
+		{"http_status", &ReadinessConfig{HTTP: config.String(failing.URL)}, true},
+		{"tcp", &ReadinessConfig{TCP: config.String(ln.Addr().String())}, false},
+		{"tcp_refused", &ReadinessConfig{TCP: config.String(closed.Addr().String())}, true},
+		{"command", &ReadinessConfig{Command: config.String(`test "$MODE" = "ready"`)}, false},
+		{"command_fails", &ReadinessConfig{Command: config.String(`test "$MODE" = "other"`)}, true},
+		{"file", &ReadinessConfig{File: config.String(file)}, false},
+		{"file_missing", &ReadinessConfig{File: config.String(filepath.Join(dir, "missing"))}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.c.Finalize()
+			err := probeReadiness(tc.c, []string{"MODE=ready"})
+			if (err != nil) != tc.err {
+				t.Errorf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRunner_Run_readiness(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cases := []struct {
+		name    string
+		command string
+		code    int
+		err     string
+	}{
+		{
+			"ready",
+			`sleep 0.2; touch "$READY_FILE"; while :; do sleep 0.05; done`,
+			0,
+			"",
+		},
+		{
+			// The exit of a child which is not ready yet is handled like any
+			// other, with its own exit code.
+			"exited",
+			`exit 3`,
+			3,
+			"",
+		},
+		{
+			"deadline",
+			`while :; do sleep 0.05; done`,
+			0,
+			"child process did not become ready: deadline of 500ms reached",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ready := filepath.Join(dir, tc.name)
+			c := DefaultConfig().Merge(&Config{
+				Exec: &config.ExecConfig{
+					Command:     []string{"sh", "-c", tc.command},
+					KillTimeout: config.TimeDuration(time.Second),
+				},
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("app")},
+				},
+				Readiness: &ReadinessConfig{
+					File:     config.String(ready),
+					Interval: config.TimeDuration(50 * time.Millisecond),
+					Deadline: config.TimeDuration(500 * time.Millisecond),
+				},
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Stop()
+
+			r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "READY_FILE", Value: ready}})
+			exitCh, err := r.Run()
+			if tc.code != 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case code := <-exitCh:
+					if code != tc.code {
+						t.Errorf("expected exit code %d, got %d", tc.code, code)
+					}
+				default:
+					t.Error("expected the exit code to be sent again")
+				}
+				return
+			}
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(ready); err != nil {
+					t.Errorf("expected the run to wait for the child to be ready: %s", err)
+				}
+				return
+			}
+
+			if _, ok := err.(*ErrNotReady); !ok {
+				t.Fatalf("expected ErrNotReady, got %v", err)
+			}
+			if !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("expected %q, got %q", tc.err, err.Error())
+			}
+		})
+	}
+}
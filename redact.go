@@ -0,0 +1,87 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// redactedMarker replaces redacted values in the marker mode.
+const redactedMarker = "[redacted]"
+
+// validRedactMode returns true if the given mode is a known redact mode.
+func validRedactMode(s string) bool {
+	for _, v := range RedactModes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// redactor hides the values of secrets and sensitive keys in log output and
+// audit events.
+type redactor struct {
+	// keys are the upper cased globs of sensitive keys.
+	keys []string
+	hash bool
+
+	// disabled logs values as they are. Audit events are redacted regardless.
+	disabled bool
+}
+
+// newRedactor returns a redactor for the given finalized configuration.
+func newRedactor(c *Config) *redactor {
+	keys := make([]string, 0, len(c.Redact.Keys))
+	for _, k := range c.Redact.Keys {
+		keys = append(keys, strings.ToUpper(k))
+	}
+	return &redactor{
+		keys:     keys,
+		hash:     config.StringVal(c.Redact.Mode) == RedactModeHash,
+		disabled: config.BoolVal(c.LogUnredacted),
+	}
+}
+
+// sensitive returns true if the value of the key should be redacted. Secret
+// is whether the value came from a secret.
+func (rd *redactor) sensitive(key string, secret bool) bool {
+	if rd == nil {
+		return false
+	}
+	return secret || anyGlobMatch(strings.ToUpper(key), rd.keys)
+}
+
+// value returns the value of the key as it should be logged. Secret is
+// whether the value came from a secret.
+func (rd *redactor) value(key, value string, secret bool) string {
+	if rd != nil && rd.disabled {
+		return value
+	}
+	return rd.audit(key, value, secret)
+}
+
+// audit returns the value of the key as it should be recorded in an audit
+// event. Unlike value, it ignores log_unredacted, so secrets never reach the
+// audit file.
+func (rd *redactor) audit(key, value string, secret bool) string {
+	if !rd.sensitive(key, secret) {
+		return value
+	}
+	if rd.hash {
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:4])
+	}
+	return redactedMarker
+}
+
+// redact returns the value of the key in the environment as it should be
+// logged.
+func (r *Runner) redact(key, value string) string {
+	return r.redactor.value(key, value, r.secretKeys[key])
+}
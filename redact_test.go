@@ -0,0 +1,126 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRedactor_value(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		c      *Config
+		key    string
+		secret bool
+		exp    string
+	}{
+		{
+			"plain",
+			&Config{},
+			"HOST",
+			false,
+			"hunter2",
+		},
+		{
+			"secret",
+			&Config{},
+			"HOST",
+			true,
+			redactedMarker,
+		},
+		{
+			"default_keys",
+			&Config{},
+			"db_password",
+			false,
+			redactedMarker,
+		},
+		{
+			"custom_keys",
+			&Config{Redact: &RedactConfig{Keys: []string{"*_KEY"}}},
+			"API_KEY",
+			false,
+			redactedMarker,
+		},
+		{
+			"custom_keys_replace_default",
+			&Config{Redact: &RedactConfig{Keys: []string{"*_KEY"}}},
+			"DB_PASSWORD",
+			false,
+			"hunter2",
+		},
+		{
+			"hash",
+			&Config{Redact: &RedactConfig{Mode: config.String(RedactModeHash)}},
+			"TOKEN",
+			false,
+			"sha256:f52fbd32",
+		},
+		{
+			"unredacted",
+			&Config{LogUnredacted: config.Bool(true)},
+			"TOKEN",
+			true,
+			"hunter2",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(tc.c)
+			c.Finalize()
+			rd := newRedactor(c)
+			if act := rd.value(tc.key, "hunter2", tc.secret); act != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_redact(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Print: &PrintConfig{
+			Format: config.String(PrintFormatDotenv),
+			Path:   config.String(t.TempDir() + "/app.env"),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "host", Value: "a"}})
+	r.Receive(r.dependencies[1], &dep.Secret{
+		Data: map[string]interface{}{"api": "hunter2"},
+	})
+	if _, err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if act := r.redact("api", "hunter2"); act != redactedMarker {
+		t.Errorf("expected secret to be redacted, got %q", act)
+	}
+	if act := r.redact("host", "a"); act != "a" {
+		t.Errorf("expected prefix value not to be redacted, got %q", act)
+	}
+
+	if _, err := NewRunner(DefaultConfig().Merge(&Config{
+		Redact: &RedactConfig{Mode: config.String("rot13")},
+	}), false); err == nil {
+		t.Error("expected error for unknown redact mode")
+	}
+}
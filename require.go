@@ -0,0 +1,175 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// ErrUnmetRequirements is returned in once mode when the environment of the
+// child process does not meet the require blocks, since the data will not
+// change afterwards.
+type ErrUnmetRequirements struct {
+	Violations []string
+}
+
+func (e *ErrUnmetRequirements) Error() string {
+	return fmt.Sprintf("environment does not meet %d requirements: %s",
+		len(e.Violations), strings.Join(e.Violations, "; "))
+}
+
+// validRequireType returns whether the type of a require block is supported.
+// An empty type does not check the value.
+func validRequireType(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, t := range RequireTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// requirement is a compiled require block.
+type requirement struct {
+	name   string
+	glob   bool
+	regex  *regexp.Regexp
+	values []string
+	typ    string
+}
+
+// newRequirement compiles a require block.
+func newRequirement(c *RequireConfig) (*requirement, error) {
+	name := config.StringVal(c.Name)
+	if name == "" {
+		return nil, fmt.Errorf("require: name is required")
+	}
+	if _, err := filepath.Match(name, ""); err != nil {
+		return nil, fmt.Errorf("require %q: invalid glob: %w", name, err)
+	}
+	if !validRequireType(config.StringVal(c.Type)) {
+		return nil, fmt.Errorf("require %q: unknown type %q, must be one of %s",
+			name, config.StringVal(c.Type), strings.Join(RequireTypes, ", "))
+	}
+
+	req := &requirement{
+		name:   name,
+		glob:   strings.ContainsAny(name, "*?["),
+		values: c.Values,
+		typ:    config.StringVal(c.Type),
+	}
+	if config.StringPresent(c.Regex) {
+		re, err := regexp.Compile(config.StringVal(c.Regex))
+		if err != nil {
+			return nil, fmt.Errorf("require %q: invalid regex: %w", name, err)
+		}
+		req.regex = re
+	}
+	return req, nil
+}
+
+// checkRequirements checks the environment the child process would be started
+// with against the require blocks, and returns every violation. Values are
+// redacted the same way as in the rest of the log output.
+func (r *Runner) checkRequirements(env map[string]string) []string {
+	var violations []string
+	for _, req := range r.requirements {
+		if !req.glob {
+			v, ok := env[req.name]
+			if !ok {
+				violations = append(violations, fmt.Sprintf("%s is not set", req.name))
+				continue
+			}
+			violations = append(violations, r.checkRequirement(req, req.name, v)...)
+			continue
+		}
+
+		var keys []string
+		for k := range env {
+			if ok, _ := filepath.Match(req.name, k); ok {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			violations = append(violations, fmt.Sprintf("no variable matches %s", req.name))
+			continue
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			violations = append(violations, r.checkRequirement(req, k, env[k])...)
+		}
+	}
+	return violations
+}
+
+// checkRequirement checks the value of a single variable against a require
+// block.
+func (r *Runner) checkRequirement(req *requirement, key, value string) []string {
+	var violations []string
+	shown := r.redact(key, value)
+
+	if req.regex != nil && !req.regex.MatchString(value) {
+		violations = append(violations, fmt.Sprintf("%s=%q does not match %s",
+			key, shown, req.regex))
+	}
+
+	if len(req.values) > 0 {
+		found := false
+		for _, v := range req.values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations = append(violations, fmt.Sprintf("%s=%q is not one of %s",
+				key, shown, strings.Join(req.values, ", ")))
+		}
+	}
+
+	if err := checkRequireType(req.typ, value); err != nil {
+		violations = append(violations, fmt.Sprintf("%s=%q is not a valid %s",
+			key, shown, req.typ))
+	}
+
+	return violations
+}
+
+// checkRequireType returns an error if the value does not parse as the given
+// type.
+func checkRequireType(typ, value string) error {
+	switch typ {
+	case RequireTypeInt:
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err
+	case RequireTypeBool:
+		_, err := strconv.ParseBool(value)
+		return err
+	case RequireTypeURL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("missing scheme or host")
+		}
+		return nil
+	case RequireTypeDuration:
+		_, err := time.ParseDuration(value)
+		return err
+	}
+	return nil
+}
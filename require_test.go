@@ -0,0 +1,260 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRunner_checkRequirements(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		require *RequireConfig
+		env     map[string]string
+		exp     []string
+	}{
+		{
+			"set",
+			&RequireConfig{Name: config.String("PORT")},
+			map[string]string{"PORT": ""},
+			nil,
+		},
+		{
+			"not_set",
+			&RequireConfig{Name: config.String("PORT")},
+			map[string]string{},
+			[]string{"PORT is not set"},
+		},
+		{
+			"glob",
+			&RequireConfig{Name: config.String("DB_*"), Type: config.String(RequireTypeInt)},
+			map[string]string{"DB_PORT": "5432", "DB_POOL": "ten", "DB_TIMEOUT": "x"},
+			[]string{`DB_POOL="ten" is not a valid int`, `DB_TIMEOUT="x" is not a valid int`},
+		},
+		{
+			"glob_no_match",
+			&RequireConfig{Name: config.String("DB_*")},
+			map[string]string{"PORT": "80"},
+			[]string{"no variable matches DB_*"},
+		},
+		{
+			"regex",
+			&RequireConfig{Name: config.String("REGION"), Regex: config.String(`^[a-z]+-[0-9]$`)},
+			map[string]string{"REGION": "EU"},
+			[]string{`REGION="EU" does not match ^[a-z]+-[0-9]$`},
+		},
+		{
+			"values",
+			&RequireConfig{Name: config.String("MODE"), Values: []string{"dev", "prod"}},
+			map[string]string{"MODE": "test"},
+			[]string{`MODE="test" is not one of dev, prod`},
+		},
+		{
+			"every_check",
+			&RequireConfig{
+				Name:   config.String("MODE"),
+				Regex:  config.String(`^p`),
+				Values: []string{"prod"},
+			},
+			map[string]string{"MODE": "test"},
+			[]string{`MODE="test" does not match ^p`, `MODE="test" is not one of prod`},
+		},
+		{
+			"bool",
+			&RequireConfig{Name: config.String("DEBUG"), Type: config.String(RequireTypeBool)},
+			map[string]string{"DEBUG": "yes"},
+			[]string{`DEBUG="yes" is not a valid bool`},
+		},
+		{
+			"url",
+			&RequireConfig{Name: config.String("API_URL"), Type: config.String(RequireTypeURL)},
+			map[string]string{"API_URL": "api.example.com/v1"},
+			[]string{`API_URL="api.example.com/v1" is not a valid url`},
+		},
+		{
+			"url_valid",
+			&RequireConfig{Name: config.String("API_URL"), Type: config.String(RequireTypeURL)},
+			map[string]string{"API_URL": "https://api.example.com/v1"},
+			nil,
+		},
+		{
+			"duration",
+			&RequireConfig{Name: config.String("TIMEOUT"), Type: config.String(RequireTypeDuration)},
+			map[string]string{"TIMEOUT": "10"},
+			[]string{`TIMEOUT="10" is not a valid duration`},
+		},
+		{
+			"redacted",
+			&RequireConfig{Name: config.String("API_KEY"), Type: config.String(RequireTypeInt)},
+			map[string]string{"API_KEY": "hunter2"},
+			[]string{`API_KEY="[redacted]" is not a valid int`},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{
+				Redact:  &RedactConfig{Keys: []string{"*_KEY"}},
+				Require: &RequireConfigs{tc.require},
+			})
+			r, err := NewRunner(c, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act := r.checkRequirements(tc.env); !reflect.DeepEqual(act, tc.exp) {
+				t.Errorf("expected %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRunner_Run_require(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Exec: &config.ExecConfig{
+			Command:     []string{"sh", "-c", "while :; do sleep 0.05; done"},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Pristine: config.Bool(true),
+		Require: &RequireConfigs{
+			&RequireConfig{Name: config.String("PORT"), Type: config.String(RequireTypeInt)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	run := func(pairs ...*dep.KeyPair) (<-chan int, error) {
+		r.Receive(r.dependencies[0], pairs)
+		return r.Run()
+	}
+
+	// The child is not started until the requirements are met.
+	exitCh, err := run(&dep.KeyPair{Key: "HOST", Value: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh != nil || r.child != nil {
+		t.Fatal("expected the child not to start")
+	}
+
+	exitCh, err = run(&dep.KeyPair{Key: "PORT", Value: "80"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh == nil {
+		t.Fatal("expected the child to start")
+	}
+	pid := r.child.Pid()
+
+	// A change which breaks the requirements keeps the current child and its
+	// environment.
+	exitCh, err = run(&dep.KeyPair{Key: "PORT", Value: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh != nil || r.child.Pid() != pid {
+		t.Fatal("expected the child to keep running")
+	}
+	if exp := []string{"PORT=80"}; !reflect.DeepEqual(r.childEnv, exp) {
+		t.Errorf("expected child env %q, got %q", exp, r.childEnv)
+	}
+	if r.env["PORT"] != "80" {
+		t.Errorf("expected the environment not to be recorded, got %v", r.env)
+	}
+
+	// In once mode, no more data is coming, so unmet requirements are an
+	// error.
+	r.once = true
+	_, err = run(&dep.KeyPair{Key: "PORT", Value: "http"})
+	typed, ok := err.(*ErrUnmetRequirements)
+	if !ok {
+		t.Fatalf("expected ErrUnmetRequirements, got %v", err)
+	}
+	if exp := `PORT="http" is not a valid int`; !strings.Contains(typed.Error(), exp) {
+		t.Errorf("expected %q in %q", exp, typed.Error())
+	}
+}
+
+func TestRunner_Run_requireSecrets(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "secrets")
+	c := DefaultConfig().Merge(&Config{
+		MaskOutput: config.Bool(true),
+		Exec: &config.ExecConfig{
+			Command:     []string{"sh", "-c", "while :; do sleep 0.05; done"},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+			&PrefixConfig{
+				Path:     config.String("secret/files"),
+				NoPrefix: config.Bool(true),
+				Files:    &FilesConfig{Dir: config.String(dir)},
+			},
+		},
+		Pristine: config.Bool(true),
+		Require: &RequireConfigs{
+			&RequireConfig{Name: config.String("PORT"), Type: config.String(RequireTypeInt)},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	var out syncBuffer
+	r.outStream = &out
+
+	run := func(port, token, password string) {
+		r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "PORT", Value: port}})
+		r.Receive(r.dependencies[1], &dep.Secret{
+			Data: map[string]interface{}{"token": token},
+		})
+		r.Receive(r.dependencies[2], &dep.Secret{
+			Data: map[string]interface{}{"password": password},
+		})
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("80", "token-old", "password-old")
+
+	// A change which breaks the requirements keeps the secret files and the
+	// masked values of the current child.
+	run("http", "token-new", "password-new")
+	if b, err := os.ReadFile(filepath.Join(dir, "password")); err != nil || string(b) != "password-old" {
+		t.Errorf("expected the secret file to be kept, got %q (%v)", string(b), err)
+	}
+	if _, err := r.mask.out.Write([]byte("token-old password-old\n")); err != nil {
+		t.Fatal(err)
+	}
+	r.mask.Flush()
+	if exp := "**** ****\n"; out.String() != exp {
+		t.Errorf("expected %q, got %q", exp, out.String())
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/child"
+	"github.com/hashicorp/consul-template/config"
+	"github.com/pkg/errors"
+)
+
+// runnerState is the environment of the runner as of a run, which is restored
+// when a safe rollout fails.
+type runnerState struct {
+	env             map[string]string
+	filteredEnv     map[string]string
+	depEnv          map[string]map[string]string
+	filteredOrigins map[string]*keyOrigin
+	childEnv        []string
+	secretFiles     map[string]*secretFile
+	secretKeys      map[string]bool
+}
+
+// saveState returns the current environment of the runner.
+func (r *Runner) saveState() *runnerState {
+	return &runnerState{
+		env:             r.env,
+		filteredEnv:     r.filteredEnv,
+		depEnv:          r.depEnv,
+		filteredOrigins: r.filteredOrigins,
+		childEnv:        r.childEnv,
+		secretFiles:     r.secretFiles,
+		secretKeys:      r.secretKeys,
+	}
+}
+
+// rollout starts a new child process with the environment from the last run
+// alongside the current one. The current child is stopped once the new one
+// has been running for the grace period, and is ready when readiness is
+// probed. If the new child fails to start, exits or is not ready before, it
+// is discarded and the runner goes back to the given last
+// known good state, so the current child keeps running.
+func (r *Runner) rollout(good *runnerState) (<-chan int, error) {
+	logger := namedLogger("runner")
+	grace := config.TimeDurationVal(r.config.Rollout.GracePeriod)
+
+	r.childEnv = r.buildChildEnv()
+	logger.Info(fmt.Sprintf("starting new child process, the current one is "+
+		"stopped if it keeps running for %s", grace))
+	started := time.Now()
+	next, mask, err := r.spawnChild()
+	if err != nil {
+		logger.Error("new child process failed to start:", err)
+		return nil, r.revert(good)
+	}
+	readiness := r.newReadiness()
+
+	// The current child process keeps its own secrets masked, along with the
+	// new ones, as it may read the secret files which were just written.
+	r.mask.setValues(append(secretValues(r.env, r.secretKeys, r.secretFiles),
+		secretValues(good.env, good.secretKeys, good.secretFiles)...))
+
+	// The dependencies lock is not held while waiting, so the status of the
+	// runner can still be read.
+	var stopped bool
+	r.withoutDependenciesLock(func() {
+		stopped, err = r.waitRollout(next, readiness, r.child.ExitCh())
+	})
+	if stopped || err != nil {
+		if readiness != nil {
+			readiness.stop()
+		}
+		next.Stop()
+		mask.Flush()
+		if stopped {
+			return nil, nil
+		}
+		logger.Error("new child process failed:", err)
+		return nil, r.revert(good)
+	}
+
+	logger.Info("new child process is still running, stopping the previous one")
+	r.stopChild()
+	r.childLock.Lock()
+	r.child = next
+	r.childLock.Unlock()
+	r.mask = mask
+	r.supervisor.started(started)
+	r.watchReadiness(readiness)
+
+	return next.ExitCh(), nil
+}
+
+// waitRollout waits for the new child process to keep running for the grace
+// period and, when readiness is probed, to become ready. It returns an error
+// if the new child exits or is not ready before the deadline, and true if the
+// runner is stopped in the meantime. If the previous child process exits
+// first, there is nothing left to keep running, so the new child replaces it
+// right away.
+func (r *Runner) waitRollout(next *child.Child, m *readinessMonitor, previous <-chan int) (bool, error) {
+	grace := time.NewTimer(config.TimeDurationVal(r.config.Rollout.GracePeriod))
+	defer grace.Stop()
+	graceCh := grace.C
+
+	var readyCh <-chan struct{}
+	var deadlineCh <-chan time.Time
+	if m != nil {
+		var stop func()
+		readyCh = m.readyCh
+		deadlineCh, stop = m.deadline()
+		defer stop()
+	}
+
+	for graceCh != nil || readyCh != nil {
+		select {
+		case <-graceCh:
+			graceCh = nil
+		case <-readyCh:
+			readyCh = nil
+		case code := <-next.ExitCh():
+			if graceCh != nil {
+				return false, fmt.Errorf("exited with code %d during the grace period", code)
+			}
+			return false, &ErrNotReady{Reason: fmt.Sprintf("exited with code %d", code)}
+		case <-deadlineCh:
+			return false, m.deadlineErr()
+		case code := <-previous:
+			namedLogger("runner").Warn(fmt.Sprintf("previous child process exited "+
+				"with code %d during the rollout, switching to the new one", code))
+			return false, nil
+		case <-r.DoneCh:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withoutDependenciesLock calls f with the dependencies lock, which the caller
+// holds, released, and takes it back after.
+func (r *Runner) withoutDependenciesLock(f func()) {
+	r.dependenciesLock.Unlock()
+	defer r.dependenciesLock.Lock()
+	f()
+}
+
+// revert goes back to the given last known good state after a failed
+// rollout, and logs the changes which caused the failure. The environment
+// which failed is not rolled out again until it changes.
+func (r *Runner) revert(good *runnerState) error {
+	logger := namedLogger("runner")
+
+	event := r.newAuditEvent(good.filteredEnv, r.filteredEnv, good.filteredOrigins, r.filteredOrigins)
+	logger.Error(fmt.Sprintf("keeping the current child process with the last "+
+		"known good environment, the new environment failed with these "+
+		"changes:\n%s", strings.Join(describeChanges(event), "\n")))
+
+	r.failedEnv = r.env
+	revertEvent := r.newAuditEvent(r.filteredEnv, good.filteredEnv, r.filteredOrigins, good.filteredOrigins)
+	r.env = good.env
+	r.filteredEnv = good.filteredEnv
+	r.depEnv = good.depEnv
+	r.filteredOrigins = good.filteredOrigins
+	r.childEnv = good.childEnv
+	r.secretFiles = good.secretFiles
+	r.secretKeys = good.secretKeys
+	if !revertEvent.empty() {
+		r.audit(revertEvent)
+	}
+
+	if err := r.applySecrets(good.env); err != nil {
+		return err
+	}
+
+	if r.config.EnvFile.Enabled() {
+		if err := r.writeEnvFile(good.filteredEnv); err != nil {
+			return errors.Wrap(err, "writing env file")
+		}
+	}
+	return nil
+}
+
+// describeChanges returns a line for each key added, removed or changed by the
+// event, with redacted values.
+func describeChanges(event *auditEvent) []string {
+	var lines []string
+	for _, c := range event.Added {
+		lines = append(lines, fmt.Sprintf("  + %s=%q", c.Key, *c.New))
+	}
+	for _, c := range event.Removed {
+		lines = append(lines, fmt.Sprintf("  - %s=%q", c.Key, *c.Old))
+	}
+	for _, c := range event.Changed {
+		lines = append(lines, fmt.Sprintf("  ~ %s=%q -> %q", c.Key, *c.Old, *c.New))
+	}
+	return lines
+}
@@ -0,0 +1,252 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+func TestRunner_Run_rollout(t *testing.T) {
+	t.Parallel()
+
+	envFile := filepath.Join(t.TempDir(), "app.env")
+	c := DefaultConfig().Merge(&Config{
+		EnvFile: &EnvFileConfig{
+			Path: config.String(envFile),
+		},
+		Exec: &config.ExecConfig{
+			Command: []string{"sh", "-c",
+				`if [ "$MODE" = "bad" ]; then exit 3; fi; while :; do sleep 0.05; done`},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Pristine: config.Bool(true),
+		Rollout: &RolloutConfig{
+			GracePeriod: config.TimeDuration(500 * time.Millisecond),
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	run := func(mode string) (<-chan int, error) {
+		r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "MODE", Value: mode}})
+		return r.Run()
+	}
+
+	exitCh, err := run("good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh == nil {
+		t.Fatal("expected the child to start")
+	}
+	pid := r.child.Pid()
+
+	// The new child exits during the grace period, so the current child keeps
+	// running with the last known good environment.
+	exitCh, err = run("bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh != nil || r.child.Pid() != pid {
+		t.Fatal("expected the current child to keep running")
+	}
+	if exp := []string{"MODE=good"}; !reflect.DeepEqual(r.childEnv, exp) {
+		t.Errorf("expected child env %q, got %q", exp, r.childEnv)
+	}
+	if r.env["MODE"] != "good" {
+		t.Errorf("expected the environment to be reverted, got %v", r.env)
+	}
+	b, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "MODE=good") {
+		t.Errorf("expected the env file to be reverted, got %q", string(b))
+	}
+
+	// The same environment is not rolled out again.
+	start := time.Now()
+	if exitCh, err = run("bad"); err != nil || exitCh != nil {
+		t.Fatalf("expected nothing to happen, got %v, %v", exitCh, err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected the failed environment to be skipped, took %s", elapsed)
+	}
+
+	// The new child keeps running for the grace period, so it replaces the
+	// current child.
+	exitCh, err = run("better")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCh == nil || r.child.Pid() == pid {
+		t.Fatal("expected the new child to replace the current one")
+	}
+	if exp := []string{"MODE=better"}; !reflect.DeepEqual(r.childEnv, exp) {
+		t.Errorf("expected child env %q, got %q", exp, r.childEnv)
+	}
+}
+
+func TestRunner_Run_rolloutSecrets(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "secrets")
+	c := DefaultConfig().Merge(&Config{
+		MaskOutput: config.Bool(true),
+		Exec: &config.ExecConfig{
+			Command: []string{"sh", "-c",
+				`if [ "$token" = "token-bad" ]; then exit 3; fi; while :; do sleep 0.05; done`},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+			&PrefixConfig{
+				Path:     config.String("secret/files"),
+				NoPrefix: config.Bool(true),
+				Files:    &FilesConfig{Dir: config.String(dir)},
+			},
+		},
+		Pristine: config.Bool(true),
+		Rollout: &RolloutConfig{
+			GracePeriod: config.TimeDuration(500 * time.Millisecond),
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	var out syncBuffer
+	r.outStream = &out
+
+	run := func(token, password string) {
+		r.Receive(r.dependencies[0], &dep.Secret{
+			Data: map[string]interface{}{"token": token},
+		})
+		r.Receive(r.dependencies[1], &dep.Secret{
+			Data: map[string]interface{}{"password": password},
+		})
+		if _, err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("token-good", "password-good")
+
+	// The new child exits during the grace period, so the secret files and
+	// masked values of the current child are restored.
+	run("token-bad", "password-bad")
+	if b, err := os.ReadFile(filepath.Join(dir, "password")); err != nil || string(b) != "password-good" {
+		t.Errorf("expected the secret file to be reverted, got %q (%v)", string(b), err)
+	}
+	if _, err := r.mask.out.Write([]byte("token-good password-good\n")); err != nil {
+		t.Fatal(err)
+	}
+	r.mask.Flush()
+	if exp := "**** ****\n"; out.String() != exp {
+		t.Errorf("expected %q, got %q", exp, out.String())
+	}
+
+	// The runner is not locked while the new child runs for the grace period.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run("token-better", "password-better")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	r.watchersHealthy()
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected the runner not to be locked, took %s", elapsed)
+	}
+	<-done
+}
+
+func TestRunner_Run_rolloutPreviousExits(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Exec: &config.ExecConfig{
+			Command: []string{"sh", "-c",
+				`if [ "$MODE" = "short" ]; then sleep 0.3; exit 0; fi; while :; do sleep 0.05; done`},
+			KillTimeout: config.TimeDuration(time.Second),
+		},
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Pristine: config.Bool(true),
+		Rollout: &RolloutConfig{
+			GracePeriod: config.TimeDuration(5 * time.Second),
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	run := func(mode string) (<-chan int, error) {
+		r.Receive(r.dependencies[0], []*dep.KeyPair{{Key: "MODE", Value: mode}})
+		return r.Run()
+	}
+
+	if _, err := run("short"); err != nil {
+		t.Fatal(err)
+	}
+	pid := r.child.Pid()
+
+	// The current child exits during the grace period, so the new child
+	// replaces it without waiting for the rest of it.
+	start := time.Now()
+	exitCh, err := run("long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the rollout to end when the current child exited, took %s", elapsed)
+	}
+	if exitCh == nil || r.child.Pid() == pid {
+		t.Fatal("expected the new child to replace the current one")
+	}
+}
+
+func TestDescribeChanges(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Redact: &RedactConfig{Keys: []string{"*_KEY"}},
+	})
+	c.Finalize()
+	r := &Runner{config: c, redactor: newRedactor(c)}
+
+	event := r.newAuditEvent(
+		map[string]string{"HOST": "a", "PORT": "80", "API_KEY": "x"},
+		map[string]string{"HOST": "b", "API_KEY": "y", "MODE": "dev"},
+		nil, nil,
+	)
+	exp := []string{
+		`  + MODE="dev"`,
+		`  - PORT="80"`,
+		`  ~ API_KEY="[redacted]" -> "[redacted]"`,
+		`  ~ HOST="a" -> "b"`,
+	}
+	if act := describeChanges(event); !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+}
@@ -7,20 +7,23 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"os"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/hashicorp/consul-template/child"
 	"github.com/hashicorp/consul-template/config"
 	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul-template/renderer"
 	"github.com/hashicorp/consul-template/watch"
 	"github.com/pkg/errors"
 )
@@ -45,6 +48,10 @@ type Runner struct {
 	// childLock is the internal lock around the child process.
 	childLock sync.RWMutex
 
+	// readiness probes whether the child process is ready. It is nil when
+	// readiness is not probed.
+	readiness *readinessMonitor
+
 	// config is the Config that created this Runner. It is used internally to
 	// construct other objects and pass data.
 	config *Config
@@ -55,6 +62,10 @@ type Runner struct {
 
 	configServiceMap map[string]*ServiceConfig
 
+	// serviceSelection is the id of the instance picked for each service
+	// using the random selection strategy, keyed by dependency.
+	serviceSelection map[string]string
+
 	// data is the latest representation of the data from Consul.
 	data map[string]interface{}
 
@@ -64,12 +75,89 @@ type Runner struct {
 	// dependenciesLock is a lock around touching the dependencies map.
 	dependenciesLock sync.Mutex
 
+	// depErrors is the last error reported by the watcher for each dependency
+	// that has not returned data since, keyed by dependency. depRetries are
+	// the dependencies whose error is retried, and retryGrace is how long
+	// after a retry is due its error is kept, waiting for the retry to fail.
+	depErrors  map[string]error
+	depRetries map[string]*depRetry
+	retryGrace time.Duration
+
+	// optionalExpired is set when the optional timeout is reached, after which
+	// optional dependencies without data count as empty. optionalSupplying is
+	// whether each optional dependency was last seen supplying data.
+	optionalExpired   bool
+	optionalSupplying map[string]bool
+
+	// cachedDeps are the dependencies whose data was loaded from the cache and
+	// has not been replaced by live data. cacheEntries are the entries of the
+	// cache file as last read or written. cacheKey is the configured key, and
+	// cacheDerivedKey the key derived from it with cacheSalt, the salt of the
+	// cache file, which encrypts secrets.
+	cachedDeps      map[string]bool
+	cacheEntries    map[string]*cacheEntry
+	cacheKey        []byte
+	cacheSalt       []byte
+	cacheDerivedKey []byte
+
+	// redactor hides sensitive values in log output. secretKeys are the keys
+	// of the environment which are set by secrets.
+	redactor   *redactor
+	secretKeys map[string]bool
+
+	// mask wraps the out and err streams given to the current child process
+	// to mask the values of secrets. Each child process gets its own. It is nil
+	// when masking is disabled or there is no child.
+	mask *outputMask
+
+	// origins is where each key set by each dependency came from, when more
+	// is known than the dependency, and is rebuilt by every run.
+	// filteredOrigins is where each key of filteredEnv came from.
+	origins         map[string]map[string]*keyOrigin
+	filteredOrigins map[string]*keyOrigin
+
 	// env is the last compiled environment.
 	env map[string]string
 
+	// filteredEnv is the last compiled environment after the exec env filters
+	// are applied. Changes to the environment are detected on this.
+	filteredEnv map[string]string
+
+	// depEnv is the environment contributed by each dependency in the last
+	// run, keyed by dependency.
+	depEnv map[string]map[string]string
+
+	// changeStrategies is the change strategy of each dependency, keyed by
+	// dependency.
+	changeStrategies map[string]string
+
+	// childEnv is the environment the child process was last started with, in
+	// KEY=value form.
+	childEnv []string
+
+	// failedEnv is the last environment which failed a safe rollout. It is not
+	// rolled out again until the environment changes.
+	failedEnv map[string]string
+
+	// requirements are the compiled require blocks, which the environment of
+	// the child process must meet before it is started.
+	requirements []*requirement
+
+	// supervisor decides when to restart the child after it exits.
+	supervisor *supervisor
+
 	// once indicates the runner should get data exactly one time and then stop.
 	once bool
 
+	// secretFiles are the secret files compiled during the current run, keyed
+	// by their path on disk. renderedFiles are the paths of the files that are
+	// currently on disk, so they can be removed when no longer needed.
+	secretFiles   map[string]*secretFile
+	renderedFiles map[string]struct{}
+
+	// renderedFilesLock is the lock around the files on disk.
+	renderedFilesLock sync.Mutex
+
 	// outStream and errStream are the io.Writer streams where the runner will
 	// write information.
 	//
@@ -86,6 +174,11 @@ type Runner struct {
 	// stopped is a boolean of whether the runner is stopped
 	stopped bool
 
+	// notifier sends state changes to the service manager, and is nil when not
+	// run by one. notifiedReady is set once it was told Envconsul is ready.
+	notifier      *notifier
+	notifiedReady bool
+
 	// watcher is the watcher this runner is using.
 	watcher *watch.Watcher
 	// dedicated token watcher
@@ -97,17 +190,28 @@ func NewRunner(config *Config, once bool) (*Runner, error) {
 	namedLogger("runner").Info("creating new runner", "once:", once)
 
 	runner := &Runner{
-		config:           config,
-		once:             once,
-		data:             make(map[string]interface{}),
-		configPrefixMap:  make(map[string]*PrefixConfig),
-		configServiceMap: make(map[string]*ServiceConfig),
-		inStream:         os.Stdin,
-		outStream:        os.Stdout,
-		errStream:        os.Stderr,
-		ErrCh:            make(chan error),
-		DoneCh:           make(chan struct{}),
-		ExitCh:           make(chan int, 1),
+		config:            config,
+		once:              once,
+		data:              make(map[string]interface{}),
+		configPrefixMap:   make(map[string]*PrefixConfig),
+		configServiceMap:  make(map[string]*ServiceConfig),
+		serviceSelection:  make(map[string]string),
+		changeStrategies:  make(map[string]string),
+		depErrors:         make(map[string]error),
+		depRetries:        make(map[string]*depRetry),
+		retryGrace:        defaultRetryGrace,
+		optionalSupplying: make(map[string]bool),
+		cachedDeps:        make(map[string]bool),
+		origins:           make(map[string]map[string]*keyOrigin),
+		secretFiles:       make(map[string]*secretFile),
+		renderedFiles:     make(map[string]struct{}),
+		notifier:          newNotifierFromEnv(),
+		inStream:          os.Stdin,
+		outStream:         os.Stdout,
+		errStream:         os.Stderr,
+		ErrCh:             make(chan error),
+		DoneCh:            make(chan struct{}),
+		ExitCh:            make(chan int, 1),
 	}
 
 	// Create the clientset
@@ -149,6 +253,33 @@ func (r *Runner) Start() {
 
 	var exitCh <-chan int
 
+	// restartCh fires when a child that exited should be restarted.
+	var restartCh <-chan time.Time
+
+	// startupCh fires when the startup timeout is reached.
+	var startupCh <-chan time.Time
+	if timeout := config.TimeDurationVal(r.config.StartupTimeout); timeout > 0 {
+		startupCh = time.After(timeout)
+	}
+
+	// optionalCh fires when optional dependencies without data should count as
+	// empty.
+	var optionalCh <-chan time.Time
+	if r.hasOptional() {
+		optionalCh = time.After(config.TimeDurationVal(r.config.OptionalTimeout))
+	}
+
+	// cacheCh fires when dependencies without live data should be loaded from
+	// the cache.
+	var cacheCh <-chan time.Time
+	if r.config.Cache.Enabled() {
+		cacheCh = time.After(config.TimeDurationVal(r.config.Cache.Deadline))
+	}
+
+	// The watchdog is pinged apart from this loop, which waits for the child
+	// process during rollouts and readiness checks.
+	go r.runWatchdog()
+
 	for {
 		select {
 		case data := <-r.watcher.DataCh():
@@ -180,7 +311,39 @@ func (r *Runner) Start() {
 		case <-r.maxTimer:
 			logger.Info("quiescence maxTimer fired")
 			r.minTimer, r.maxTimer = nil, nil
+		case err := <-r.watcher.ServerErrCh():
+			// These are errors which are retried. They are only recorded, so
+			// they can be reported if the dependency never returns data.
+			logger.Debug("watcher reported retryable error:", err)
+			r.recordRetryableError(err)
+			continue
+		case <-cacheCh:
+			cacheCh = nil
+			loaded, err := r.loadCache()
+			if err != nil {
+				logger.Warn("could not load cache:", err)
+				continue
+			}
+			if loaded == 0 {
+				continue
+			}
+		case <-optionalCh:
+			logger.Info("optional timeout reached")
+			optionalCh = nil
+			r.expireOptional()
+		case <-startupCh:
+			missing := r.missingDependencies()
+			if len(missing) > 0 {
+				r.ErrCh <- &ErrStartupTimeout{
+					Timeout: config.TimeDurationVal(r.config.StartupTimeout),
+					Missing: missing,
+				}
+				return
+			}
+			startupCh = nil
+			continue
 		case err := <-r.watcher.ErrCh():
+			d := r.recordError(err)
 			// Intentionally do not send the error back up to the runner.
 			// Eventually, once Consul API implements errwrap and multierror,
 			// we can check the "type" of error and conditionally alert back.
@@ -189,7 +352,7 @@ func (r *Runner) Start() {
 			//   errCh <- err
 			// }
 			logger.Error("watcher reported error:", err)
-			if r.once {
+			if r.once && (d == nil || !r.optional(d)) {
 				r.ErrCh <- err
 				return
 			}
@@ -201,7 +364,30 @@ func (r *Runner) Start() {
 				return
 			}
 		case code := <-exitCh:
+			exitCh = nil
+			r.mask.Flush()
+			if r.supervisor.restartable(code) {
+				if delay, ok := r.supervisor.next(time.Now()); ok {
+					logger.Warn(fmt.Sprintf("child exited with code %d, restarting in %s",
+						code, delay))
+					restartCh = time.After(delay)
+					continue
+				}
+				logger.Error(fmt.Sprintf("child exited with code %d, giving up after "+
+					"%d restarts in %s", code, len(r.supervisor.restarts),
+					config.TimeDurationVal(r.config.Supervise.Window)))
+			}
 			r.ExitCh <- code
+		case <-restartCh:
+			restartCh = nil
+			logger.Info("restarting child")
+			nexitCh, err := r.startChild()
+			if err != nil {
+				r.ErrCh <- err
+				return
+			}
+			exitCh = nexitCh
+			continue
 		case <-r.DoneCh:
 			logger.Info("received finish")
 			return
@@ -214,6 +400,7 @@ func (r *Runner) Start() {
 			r.ErrCh <- err
 			return
 		}
+		r.notifyRun()
 
 		// It's possible that we didn't start a process, in which case no exitCh
 		// is returned. In this case, we should assume our current process is still
@@ -221,6 +408,7 @@ func (r *Runner) Start() {
 		// process is spawned, so we need to watch a new exitCh.
 		if nexitCh != nil {
 			exitCh = nexitCh
+			restartCh = nil
 		}
 	}
 }
@@ -238,6 +426,8 @@ func (r *Runner) Stop() {
 	logger.Info("stopping")
 	r.stopWatchers()
 	r.stopChild()
+	r.watchReadiness(nil)
+	r.removeSecretFiles()
 
 	if err := r.deletePid(); err != nil {
 		logger.Warn(fmt.Sprintf("could not remove pid at %#v: %s",
@@ -255,6 +445,13 @@ func (r *Runner) Receive(d dep.Dependency, data interface{}) {
 	defer r.dependenciesLock.Unlock()
 	namedLogger("runner").Debug("receiving dependency", d.String())
 	r.data[d.String()] = data
+	delete(r.depErrors, d.String())
+	delete(r.depRetries, d.String())
+
+	if r.cachedDeps[d.String()] {
+		namedLogger("runner").Info("switching from the cache to live data for", d)
+		delete(r.cachedDeps, d.String())
+	}
 }
 
 // Signal sends a signal to the child process, if it exists. Any errors that
@@ -274,7 +471,199 @@ func (r *Runner) Run() (<-chan int, error) {
 	logger := namedLogger("runner")
 	logger.Info("running")
 
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	// The secret files and secret keys of the applied environment are put back
+	// when the new one is not applied, so the running child keeps its secrets
+	// on disk and masked in its output. A failed rollout goes back to this
+	// state too.
+	good := r.saveState()
+	restoreSecrets := func() {
+		r.secretFiles, r.secretKeys = good.secretFiles, good.secretKeys
+	}
+
+	env, depEnv, ok, err := r.buildEnv()
+	if err != nil || !ok {
+		restoreSecrets()
+		return nil, err
+	}
+
+	// Print the final environment
+	logger.Trace("Environment:")
+	for k, v := range env {
+		logger.Trace(fmt.Sprintf("%s=%q", k, r.redact(k, v)))
+	}
+
+	// If the resulting map is the same, do not do anything. We use a length
+	// check first to get a small performance increase if something has changed
+	// so we don't immediately delegate to reflect which is slow.
+	if len(r.env) == len(env) && reflect.DeepEqual(r.env, env) {
+		logger.Info("environment was the same")
+		// The contents of secret files can change without the environment
+		// changing.
+		if err := r.applySecrets(env); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if r.failedEnv != nil && reflect.DeepEqual(r.failedEnv, env) {
+		logger.Info("environment failed to roll out before, keeping the current child process")
+		restoreSecrets()
+		return nil, nil
+	}
+
+	// The child process is not started, or not restarted, while the
+	// environment it would get does not meet the require blocks. The
+	// environment is not recorded, so it is checked again on the next run.
+	if violations := r.checkRequirements(r.childEnvMap(env)); len(violations) > 0 {
+		for _, v := range violations {
+			logger.Error("requirement not met:", v)
+		}
+		restoreSecrets()
+		if r.once {
+			return nil, &ErrUnmetRequirements{Violations: violations}
+		}
+		if r.child != nil {
+			logger.Error("requirements not met, keeping the current child process")
+		} else {
+			logger.Error("requirements not met, not starting the child process")
+		}
+		return nil, nil
+	}
+
+	if err := r.applySecrets(env); err != nil {
+		return nil, err
+	}
+
+	// Changes are detected on the environment after the exec env filters are
+	// applied, since keys which never reach the child should not restart it.
+	// The parent environment is intentionally not included.
+	filteredEnv := make(map[string]string, len(env))
+	for k, v := range env {
+		filteredEnv[k] = v
+	}
+	filteredEnv = r.applyConfigEnv(filteredEnv)
+	first := r.filteredEnv == nil
+	changed := changedKeys(r.filteredEnv, filteredEnv)
+	prevDepEnv := r.depEnv
+
+	origins := r.keyOrigins(depEnv, filteredEnv)
+	if event := r.newAuditEvent(r.filteredEnv, filteredEnv, r.filteredOrigins, origins); !event.empty() {
+		r.audit(event)
+	}
+	r.filteredOrigins = origins
+
+	// Update the environment
+	r.env = env
+	r.filteredEnv = filteredEnv
+	r.depEnv = depEnv
+	r.failedEnv = nil
+
+	// The cache is not needed to run the child, so failing to write it is only
+	// logged.
+	if r.config.Cache.Enabled() {
+		if err := r.writeCache(); err != nil {
+			logger.Warn("could not write cache:", err)
+		}
+	}
+
+	if !first && len(changed) == 0 {
+		logger.Info("environment was the same after filtering")
+		return nil, nil
+	}
+
+	// In print mode, write out the environment instead of running a child
+	// process.
+	if r.config.Print.Enabled() {
+		if err := r.printEnv(filteredEnv); err != nil {
+			return nil, errors.Wrap(err, "printing environment")
+		}
+		if r.once {
+			r.Stop()
+		}
+		return nil, nil
+	}
+
+	if r.config.EnvFile.Enabled() {
+		if err := r.writeEnvFile(filteredEnv); err != nil {
+			return nil, errors.Wrap(err, "writing env file")
+		}
+	}
+
+	if r.child != nil {
+		restartKeys := r.restartKeys(changed)
+		if len(restartKeys) == 0 {
+			logger.Info(fmt.Sprintf("environment changed, but none of the changed "+
+				"keys restart the child: %s", strings.Join(changed, ", ")))
+			r.childEnv = r.buildChildEnv()
+			return nil, nil
+		}
+
+		switch r.changeStrategy(prevDepEnv, depEnv, restartKeys) {
+		case ChangeStrategyExit:
+			logger.Info("environment changed, stopping child process and exiting")
+			r.stopChild()
+			return nil, &ErrEnvChanged{}
+		case ChangeStrategySignal:
+			sig := config.SignalVal(r.config.Exec.ReloadSignal)
+			logger.Info(fmt.Sprintf("environment changed, sending %s to child process", sig))
+			r.childEnv = r.buildChildEnv()
+			if err := r.Signal(sig); err != nil {
+				return nil, errors.Wrap(err, "signaling child")
+			}
+			return nil, nil
+		default:
+			if r.config.Rollout.Enabled() {
+				return r.rollout(good)
+			}
+			logger.Info("stopping existing child process")
+			r.stopChild()
+			r.watchReadiness(nil)
+		}
+	}
+
+	r.childEnv = r.buildChildEnv()
+
+	exitCh, err := r.startChild()
+	if err != nil {
+		return nil, err
+	}
+	readiness := r.currentReadiness()
+	if readiness == nil {
+		return exitCh, nil
+	}
+
+	// The change is only done once the new child process is ready. The
+	// dependencies lock is not held while waiting, so the status of the runner
+	// can still be read.
+	logger.Info("waiting for the child process to become ready")
+	r.withoutDependenciesLock(func() {
+		exitCh, err = r.waitReady(readiness, exitCh)
+	})
+	if err != nil {
+		if r.once {
+			r.stopChild()
+			return nil, err
+		}
+		logger.Error(err.Error())
+	}
+	return exitCh, nil
+}
+
+// buildEnv merges the data of every dependency into the environment, and also
+// returns the environment of each dependency. It returns false if a
+// dependency does not have data yet. The caller must hold the dependencies
+// lock.
+func (r *Runner) buildEnv() (map[string]string, map[string]map[string]string, bool, error) {
+	logger := namedLogger("runner")
+
 	env := make(map[string]string)
+	depEnv := make(map[string]map[string]string, len(r.dependencies))
+	envOrigins := make(map[string]*keyOrigin)
+	missingKeys := false
+	r.secretFiles = make(map[string]*secretFile)
+	r.origins = make(map[string]map[string]*keyOrigin)
 
 	// Iterate over each dependency and pull out its data. If any dependencies do
 	// not have data yet, this function will immediately return because we cannot
@@ -282,49 +671,120 @@ func (r *Runner) Run() (<-chan int, error) {
 	//
 	// We iterate over the list of config prefixes so that order is maintained,
 	// since order in a map is not deterministic.
-	r.dependenciesLock.Lock()
-	defer r.dependenciesLock.Unlock()
 	for _, d := range r.dependencies {
 		data, ok := r.data[d.String()]
-		if !ok {
+		if !ok && !r.optionalEmpty(d) {
 			logger.Info("missing data for", d)
-			return nil, nil
+			return nil, nil, false, nil
+		}
+		if r.optional(d) {
+			r.logOptional(d, ok)
 		}
 
+		// Each dependency's values are kept apart, so that the change strategy
+		// can be picked from the dependencies that changed.
+		denv := make(map[string]string)
+		if !ok {
+			depEnv[d.String()] = denv
+			continue
+		}
+		var err error
 		switch typed := d.(type) {
 		case *dep.KVListQuery:
-			r.appendPrefixes(env, typed, data)
+			err = r.appendPrefixes(denv, typed, data)
 		case *dep.VaultReadQuery:
-			r.appendSecrets(env, typed, data)
+			err = r.appendSecrets(denv, typed, data)
 		case *dep.CatalogServiceQuery:
-			r.appendServices(env, typed, data)
+			err = r.appendServices(denv, typed, data)
+		case *dep.HealthServiceQuery:
+			err = r.appendServices(denv, typed, data)
 		default:
-			return nil, fmt.Errorf("unknown dependency type %T", typed)
+			return nil, nil, false, fmt.Errorf("unknown dependency type %T", typed)
+		}
+
+		// Missing required keys hold up the run like missing data, except in
+		// once mode, where no more data is coming.
+		if typed, ok := err.(*ErrMissingKeys); ok && !r.once {
+			logger.Warn(fmt.Sprintf("waiting for required keys: %s", typed))
+			r.depErrors[d.String()] = err
+			missingKeys = true
+			continue
+		}
+		if _, ok := r.depErrors[d.String()].(*ErrMissingKeys); ok {
+			delete(r.depErrors, d.String())
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		depEnv[d.String()] = denv
+		for k, v := range denv {
+			origin, ok := r.origins[d.String()][k]
+			if !ok {
+				origin = &keyOrigin{Source: d.String()}
+			}
+			if current, ok := env[k]; ok {
+				replace, err := r.resolveConflict(k, current, v, envOrigins[k], origin)
+				if err != nil {
+					return nil, nil, false, err
+				}
+				if !replace {
+					delete(denv, k)
+					continue
+				}
+			}
+			env[k] = v
+			envOrigins[k] = origin
 		}
 	}
 
-	// Print the final environment
-	logger.Trace("Environment:")
-	for k, v := range env {
-		logger.Trace(fmt.Sprintf("%s=%q", k, v))
+	if missingKeys {
+		return nil, nil, false, nil
 	}
 
-	// If the resulting map is the same, do not do anything. We use a length
-	// check first to get a small performance increase if something has changed
-	// so we don't immediately delegate to reflect which is slow.
-	if len(r.env) == len(env) && reflect.DeepEqual(r.env, env) {
-		logger.Info("environment was the same")
-		return nil, nil
+	if err := r.resolveParentConflicts(env, depEnv, envOrigins); err != nil {
+		return nil, nil, false, err
 	}
 
-	// Update the environment
-	r.env = env
+	// Keys set by secrets are remembered, so their values can be redacted in
+	// log output.
+	r.secretKeys = make(map[string]bool)
+	for _, d := range r.dependencies {
+		if _, ok := d.(*dep.VaultReadQuery); ok {
+			for k := range depEnv[d.String()] {
+				if origin, ok := r.origins[d.String()][k]; ok && !origin.secret {
+					continue
+				}
+				r.secretKeys[k] = true
+			}
+		}
+	}
 
-	if r.child != nil {
-		logger.Info("stopping existing child process")
-		r.stopChild()
+	return env, depEnv, true, nil
+}
+
+// buildChildEnv returns the environment for the child process in KEY=value
+// form, from the last compiled environment and, unless pristine, the current
+// process environment.
+func (r *Runner) buildChildEnv() []string {
+	filteredEnv := r.childEnvMap(r.env)
+
+	// Prepare the final environment. Note that it's CRUCIAL for us to
+	// initialize this slice to an empty one vs. a nil one, since that's
+	// how the child process class decides whether to pull in the parent's
+	// environment or not, and we control that via -pristine.
+	cmdEnv := make([]string, 0)
+	for k, v := range filteredEnv {
+		cmdEnv = append(cmdEnv, fmt.Sprintf("%s=%s", k, v))
 	}
 
+	return cmdEnv
+}
+
+// childEnvMap returns the environment the child process would be started with
+// for the given environment: the parent environment unless pristine, the
+// given environment, and then the exec env filters.
+func (r *Runner) childEnvMap(env map[string]string) map[string]string {
 	// Create a new environment
 	newEnv := make(map[string]string)
 
@@ -334,35 +794,58 @@ func (r *Runner) Run() (<-chan int, error) {
 			list := strings.SplitN(v, "=", 2)
 			newEnv[list[0]] = list[1]
 		}
+
+		// The service manager variables and the cache key are meant for
+		// Envconsul, not the child.
+		for _, k := range notifyEnv {
+			delete(newEnv, k)
+		}
+		delete(newEnv, config.StringVal(r.config.Cache.KeyEnv))
 	}
 
 	// Add our custom values, overwriting any existing ones.
-	for k, v := range r.env {
+	for k, v := range env {
 		newEnv[k] = v
 	}
 
-	filteredEnv := r.applyConfigEnv(newEnv)
+	return r.applyConfigEnv(newEnv)
+}
 
-	// Prepare the final environment. Note that it's CRUCIAL for us to
-	// initialize this slice to an empty one vs. a nil one, since that's
-	// how the child process class decides whether to pull in the parent's
-	// environment or not, and we control that via -pristine.
-	cmdEnv := make([]string, 0)
-	for k, v := range filteredEnv {
-		cmdEnv = append(cmdEnv, fmt.Sprintf("%s=%s", k, v))
+// startChild starts the child process with the environment from the last
+// run.
+func (r *Runner) startChild() (<-chan int, error) {
+	child, mask, err := r.spawnChild()
+	if err != nil {
+		return nil, err
 	}
+	r.child = child
+	r.mask = mask
+	r.supervisor.started(time.Now())
+	r.watchReadiness(r.newReadiness())
 
+	return child.ExitCh(), nil
+}
+
+// spawnChild starts a new child process with the environment from the last
+// run, without replacing the current one. It returns the mask of the output of
+// the new child, if any.
+func (r *Runner) spawnChild() (*child.Child, *outputMask, error) {
 	args, subshell, err := child.CommandPrep(r.config.Exec.Command)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing command")
+		return nil, nil, errors.Wrap(err, "parsing command")
+	}
+	var stdout, stderr io.Writer = r.outStream, r.errStream
+	mask := r.newChildMask()
+	if mask != nil {
+		stdout, stderr = mask.out, mask.err
 	}
 	child, err := child.New(&child.NewInput{
 		Stdin:        r.inStream,
-		Stdout:       r.outStream,
-		Stderr:       r.errStream,
+		Stdout:       stdout,
+		Stderr:       stderr,
 		Command:      args[0],
 		Args:         args[1:],
-		Env:          cmdEnv,
+		Env:          r.childEnv,
 		Timeout:      0, // Allow running indefinitely
 		ReloadSignal: config.SignalVal(r.config.Exec.ReloadSignal),
 		KillSignal:   config.SignalVal(r.config.Exec.KillSignal),
@@ -371,27 +854,42 @@ func (r *Runner) Run() (<-chan int, error) {
 		Setpgid:      subshell, // only setpgid for 'sh -c' subshell calls
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "spawning child")
+		return nil, nil, errors.Wrap(err, "spawning child")
 	}
 	if err := child.Start(); err != nil {
-		return nil, errors.Wrap(err, "starting child")
+		return nil, nil, errors.Wrap(err, "starting child")
 	}
-	r.child = child
-
-	return child.ExitCh(), nil
+	return child, mask, nil
 }
 
-func applyFormatTemplate(contents, key string) (string, error) {
-	funcs := template.FuncMap{
-		"key": func() (string, error) {
-			return key, nil
-		},
-		"replaceKey": replaceKey,
+// printEnv writes the environment to the configured print path, or to the
+// runner's out stream if no path was given.
+func (r *Runner) printEnv(env map[string]string) error {
+	contents, err := formatEnv(config.StringVal(r.config.Print.Format), env)
+	if err != nil {
+		return err
 	}
 
-	tmpl, err := template.New("filter").Funcs(funcs).Parse(contents)
+	path := config.StringVal(r.config.Print.Path)
+	if path == "" {
+		_, err := r.outStream.Write(contents)
+		return err
+	}
+
+	namedLogger("runner").Info("writing environment to", path)
+	_, err = renderer.Render(&renderer.RenderInput{
+		Contents:       contents,
+		CreateDestDirs: true,
+		Path:           path,
+		Perms:          config.FileModeVal(r.config.Print.Perms),
+	})
+	return err
+}
+
+func applyFormatTemplate(contents, path, key string) (string, error) {
+	tmpl, err := template.New("filter").Funcs(formatFuncs(path, key)).Parse(contents)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	var buf bytes.Buffer
@@ -402,6 +900,17 @@ func applyFormatTemplate(contents, key string) (string, error) {
 	return buf.String(), nil
 }
 
+// formatFuncs returns the functions available to the format templates of
+// prefixes, secrets and keys.
+func formatFuncs(path, key string) template.FuncMap {
+	funcs := templateFuncs(path)
+	funcs["key"] = func() (string, error) {
+		return key, nil
+	}
+	funcs["replaceKey"] = replaceKey
+	return funcs
+}
+
 func replaceKey(args ...string) string {
 	if len(args) != 3 {
 		return args[0]
@@ -413,19 +922,20 @@ func replaceKey(args ...string) string {
 }
 
 func applyPathTemplate(contents string) (string, error) {
-	funcs := template.FuncMap{
-		"env": func(key string) (string, error) {
-			envVar, exists := os.LookupEnv(key)
-			if !exists {
-				return "", fmt.Errorf("unable to read environment variable %q in template %q", key, contents)
-			}
-			return envVar, nil
-		},
+	// The path is what the template computes, so it is not available.
+	funcs := templateFuncs("")
+	delete(funcs, "path")
+	funcs["env"] = func(key string) (string, error) {
+		envVar, exists := os.LookupEnv(key)
+		if !exists {
+			return "", fmt.Errorf("unable to read environment variable %q in template %q", key, contents)
+		}
+		return envVar, nil
 	}
 
 	tmpl, err := template.New("path").Funcs(funcs).Parse(contents)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	var buf bytes.Buffer
@@ -436,19 +946,36 @@ func applyPathTemplate(contents string) (string, error) {
 	return buf.String(), nil
 }
 
-func applyServiceTemplate(contents, service, key string) (string, error) {
-	funcs := template.FuncMap{
-		"service": func() (string, error) {
-			return service, nil
-		},
-		"key": func() (string, error) {
-			return key, nil
-		},
+func applyServiceTemplate(contents, query, service, key string) (string, error) {
+	return executeServiceTemplate(contents, serviceFuncs(query, service, key))
+}
+
+// applyIndexedServiceTemplate is like applyServiceTemplate, with an additional
+// index function for the position of the instance.
+func applyIndexedServiceTemplate(contents, query, service, key string, index int) (string, error) {
+	funcs := serviceFuncs(query, service, key)
+	funcs["index"] = indexFunc(index)
+
+	return executeServiceTemplate(contents, funcs)
+}
+
+// serviceFuncs returns the functions available to the format templates of
+// services, where path is the query of the service.
+func serviceFuncs(query, service, key string) template.FuncMap {
+	funcs := templateFuncs(query)
+	funcs["service"] = func() (string, error) {
+		return service, nil
+	}
+	funcs["key"] = func() (string, error) {
+		return key, nil
 	}
+	return funcs
+}
 
+func executeServiceTemplate(contents string, funcs template.FuncMap) (string, error) {
 	tmpl, err := template.New("filter").Funcs(funcs).Parse(contents)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	var buf bytes.Buffer
@@ -459,75 +986,149 @@ func applyServiceTemplate(contents, service, key string) (string, error) {
 	return buf.String(), nil
 }
 
-func (r *Runner) appendServices(env map[string]string, d *dep.CatalogServiceQuery, data interface{}) (err error) {
-	typed, ok := data.([]*dep.CatalogService)
+func (r *Runner) appendServices(env map[string]string, d dep.Dependency, data interface{}) error {
+	typed, ok := serviceInstances(data)
 	if !ok {
 		return fmt.Errorf("error converting to service %s", d)
 	}
 
-	for _, ser := range typed {
-		serKV := make(map[string]string)
-		cs := r.configServiceMap[d.String()]
+	cs := r.configServiceMap[d.String()]
+	if cs == nil {
+		cs = DefaultServiceConfig()
+		cs.Finalize()
+	}
 
-		keyFormat := ser.ServiceName + "/id"
-		if cs != nil && config.StringPresent(cs.FormatId) {
-			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatId), ser.ServiceName, "id")
-			if err != nil {
-				return err
+	query := config.StringVal(cs.Query)
+	serKV := make(map[string]string)
+
+	// The single value variables, for the selected instance.
+	if ser := r.selectService(d.String(), config.StringVal(cs.Select), typed); ser != nil {
+		formats := map[string]*string{
+			"id":      cs.FormatId,
+			"name":    cs.FormatName,
+			"address": cs.FormatAddress,
+			"tag":     cs.FormatTag,
+			"port":    cs.FormatPort,
+		}
+		values := ser.values()
+		for _, field := range serviceFields {
+			keyFormat := ser.Name + "/" + field
+			if config.StringPresent(formats[field]) {
+				var err error
+				keyFormat, err = applyServiceTemplate(config.StringVal(formats[field]), query, ser.Name, field)
+				if err != nil {
+					return err
+				}
 			}
+			serKV[keyFormat] = values[field]
 		}
-		serKV[keyFormat] = ser.ServiceID
 
-		keyFormat = ser.ServiceName + "/name"
-		if cs != nil && config.StringPresent(cs.FormatName) {
-			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatName), ser.ServiceName, "name")
-			if err != nil {
-				return err
+		for _, extra := range ser.extras(cs) {
+			keyFormat := ser.Name + "/" + extra.field
+			if config.StringPresent(extra.format) {
+				var err error
+				keyFormat, err = applyServiceTemplate(config.StringVal(extra.format), query, ser.Name, extra.key)
+				if err != nil {
+					return err
+				}
 			}
+			serKV[keyFormat] = extra.value
 		}
-		serKV[keyFormat] = ser.ServiceName
+	}
 
-		keyFormat = ser.ServiceName + "/address"
-		if cs != nil && config.StringPresent(cs.FormatAddress) {
-			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatAddress), ser.ServiceName, "address")
-			if err != nil {
-				return err
+	name := serviceQueryName(query)
+	if len(typed) > 0 {
+		name = typed[0].Name
+	}
+
+	// The variables for every instance, in the order returned by Consul.
+	if config.BoolVal(cs.Indexed) {
+		for i, ser := range typed {
+			values := ser.values()
+			fields := make([]string, 0, len(serviceFields))
+			fields = append(fields, serviceFields...)
+			for _, extra := range ser.extras(cs) {
+				fields = append(fields, extra.field)
+				values[extra.field] = extra.value
+			}
+
+			for _, field := range fields {
+				keyFormat := fmt.Sprintf("%s/%d/%s", ser.Name, i, field)
+				if config.StringPresent(cs.FormatIndexed) {
+					var err error
+					keyFormat, err = applyIndexedServiceTemplate(config.StringVal(cs.FormatIndexed), query, ser.Name, field, i)
+					if err != nil {
+						return err
+					}
+				}
+				serKV[keyFormat] = values[field]
 			}
 		}
-		serKV[keyFormat] = ser.ServiceAddress
+	}
+
+	if config.BoolVal(cs.Aggregate) {
+		addrs := make([]string, 0, len(typed))
+		for _, ser := range typed {
+			addrs = append(addrs, ser.hostPort())
+		}
 
-		keyFormat = ser.ServiceName + "/tag"
-		if cs != nil && config.StringPresent(cs.FormatTag) {
-			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatTag), ser.ServiceName, "tag")
+		keyFormat := name + "/addresses"
+		if config.StringPresent(cs.FormatAddresses) {
+			var err error
+			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatAddresses), query, name, "addresses")
 			if err != nil {
 				return err
 			}
 		}
-		serKV[keyFormat] = strings.Join([]string(ser.ServiceTags), ",")
+		serKV[keyFormat] = strings.Join(addrs, ",")
+	}
 
-		keyFormat = ser.ServiceName + "/port"
-		if cs != nil && config.StringPresent(cs.FormatPort) {
-			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatPort), ser.ServiceName, "port")
+	if config.BoolVal(cs.Indexed) || config.BoolVal(cs.Aggregate) {
+		keyFormat := name + "/count"
+		if config.StringPresent(cs.FormatCount) {
+			var err error
+			keyFormat, err = applyServiceTemplate(config.StringVal(cs.FormatCount), query, name, "count")
 			if err != nil {
 				return err
 			}
 		}
-		serKV[keyFormat] = strconv.Itoa(ser.ServicePort)
+		serKV[keyFormat] = strconv.Itoa(len(typed))
+	}
 
-		for key, value := range serKV {
-			if config.BoolVal(r.config.Upcase) {
-				key = strings.ToUpper(key)
-			}
+	// Keys are handled in order, so that keys which are the same after being
+	// transformed resolve the same way on every run.
+	for _, key := range sortedKeys(serKV) {
+		value := serKV[key]
+		origin := &keyOrigin{Source: d.String(), RawKey: key}
 
-			if config.BoolVal(r.config.Sanitize) {
-				key = InvalidRegexp.ReplaceAllString(key, "_")
-			}
+		if config.BoolVal(r.config.Upcase) {
+			before := key
+			key = strings.ToUpper(key)
+			origin.transform("upcase", before, key)
+		}
 
-			env[key] = value
+		if config.BoolVal(r.config.Sanitize) {
+			before := key
+			key = InvalidRegexp.ReplaceAllString(key, "_")
+			origin.transform("sanitize", before, key)
+		}
+
+		if current, ok := env[key]; ok {
+			replace, err := r.resolveConflict(key, current, value,
+				r.origins[d.String()][key], origin)
+			if err != nil {
+				return err
+			}
+			if !replace {
+				continue
+			}
 		}
+
+		env[key] = value
+		r.setOrigin(d, key, origin)
 	}
 
-	return
+	return nil
 }
 
 func (r *Runner) appendPrefixes(
@@ -543,54 +1144,183 @@ func (r *Runner) appendPrefixes(
 	// Get the PrefixConfig so we can get configuration from it.
 	cp := r.configPrefixMap[d.String()]
 
-	// For each pair, update the environment hash. Subsequent runs could
-	// overwrite an existing key.
+	// This is the path available to format templates.
+	sourcePath, err := applyPathTemplate(config.StringVal(cp.Path))
+	if err != nil {
+		return err
+	}
+
+	logger := namedLogger("runner")
+
+	keyFormats, applyPerKeyFormat := perKeyFormats(cp)
+
+	// Expand documents into one value per nested key first, so that key
+	// blocks apply to the decoded keys. Empty values are folders, or keys
+	// without a document yet.
+	type decodedPair struct {
+		pair   *dep.KeyPair
+		values map[string]string
+	}
+	decoded := make([]decodedPair, 0, len(typed))
 	for _, pair := range typed {
-		key, value := pair.Key, string(pair.Value)
+		values := map[string]string{pair.Key: pair.Value}
+		if decode := config.StringVal(cp.Decode); decode != "" {
+			if strings.TrimSpace(pair.Value) == "" {
+				continue
+			}
+			doc, err := decodeValue(decode, pair.Value)
+			if err == nil {
+				values, err = encodeValue(pair.Key, doc,
+					ValueEncodingFlatten, config.StringVal(cp.Separator))
+			}
+			if err != nil {
+				if config.BoolVal(cp.Strict) {
+					return fmt.Errorf("key '%s' from %s: %w", pair.Key, d, err)
+				}
+				logger.Warn(fmt.Sprintf("skipping key '%s' from %s, %s", pair.Key, d, err))
+				continue
+			}
+		}
+		decoded = append(decoded, decodedPair{pair: pair, values: values})
+	}
 
-		// It is not possible to have an environment variable that is blank, but
-		// it is possible to have an environment variable _value_ that is blank.
-		if strings.TrimSpace(key) == "" {
-			continue
+	// Keys with a default value are added when missing, and missing required
+	// keys hold up the run. Decoded keys and the keys holding the documents
+	// are both present.
+	defaults := make(map[string]bool)
+	if applyPerKeyFormat {
+		present := make(map[string]bool, len(typed))
+		for _, pair := range typed {
+			present[pair.Key] = true
+		}
+		for _, dp := range decoded {
+			for k := range dp.values {
+				present[k] = true
+			}
+		}
+		var missing []string
+		for _, k := range *cp.Keys {
+			name := config.StringVal(k.Name)
+			if present[name] {
+				continue
+			}
+			present[name] = true
+			switch {
+			case k.Default != nil:
+				logger.Debug(fmt.Sprintf("using the default value of missing key '%s' from %s", name, d))
+				value := config.StringVal(k.Default)
+				decoded = append(decoded, decodedPair{
+					pair:   &dep.KeyPair{Key: name, Value: value},
+					values: map[string]string{name: value},
+				})
+				defaults[name] = true
+			case config.BoolVal(k.Required):
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return &ErrMissingKeys{Dependency: d.String(), Keys: missing}
 		}
+	}
 
-		// NoPrefix is nil when not set in config. Default to excluding prefix for Consul keys.
-		if cp.NoPrefix != nil && !config.BoolVal(cp.NoPrefix) {
-			pc, ok := r.configPrefixMap[d.String()]
-			if !ok {
-				return fmt.Errorf("missing dependency %s", d)
+	// For each pair, update the environment hash. Subsequent runs could
+	// overwrite an existing key.
+	for _, dp := range decoded {
+		pair, values := dp.pair, dp.values
+
+		for _, originalKey := range sortedKeys(values) {
+			value := values[originalKey]
+			// It is not possible to have an environment variable that is blank, but
+			// it is possible to have an environment variable _value_ that is blank.
+			if strings.TrimSpace(originalKey) == "" {
+				continue
 			}
 
-			// Replace the invalid path chars such as slashes with underscores
-			path := InvalidRegexp.ReplaceAllString(config.StringVal(pc.Path), "_")
+			// Per-key configuration applies to the key as stored in Consul, before
+			// any other transformation. Decoded keys also match the configuration
+			// of the key holding the document.
+			keys := []string{originalKey}
+			if applyPerKeyFormat {
+				keyFormat, ok := keyFormats[originalKey]
+				if !ok {
+					keyFormat, ok = keyFormats[pair.Key]
+				}
+				if !ok {
+					logger.Debug(fmt.Sprintf("skipping key '%s' since it is not listed in configuration", originalKey))
+					continue
+				}
+				if keys, err = applyKeyFormats(keyFormat, sourcePath, originalKey); err != nil {
+					return err
+				}
+			}
 
-			// Prefix the key value with the path value.
-			key = fmt.Sprintf("%s_%s", path, key)
-		}
+			for _, key := range keys {
+				origin := &keyOrigin{
+					Source:      d.String(),
+					ModifyIndex: pair.ModifyIndex,
+					RawKey:      originalKey,
+				}
+				if defaults[pair.Key] {
+					origin.Transforms = append(origin.Transforms, "default: key is missing")
+				}
+				origin.transform("key format", originalKey, key)
 
-		// If the user specified a custom format, apply that here.
-		if config.StringPresent(cp.Format) {
-			key, err = applyFormatTemplate(config.StringVal(cp.Format), key)
-			if err != nil {
-				return err
-			}
-		}
+				// NoPrefix is nil when not set in config. Default to excluding prefix for Consul keys.
+				if cp.NoPrefix != nil && !config.BoolVal(cp.NoPrefix) {
+					pc, ok := r.configPrefixMap[d.String()]
+					if !ok {
+						return fmt.Errorf("missing dependency %s", d)
+					}
 
-		if config.BoolVal(r.config.Sanitize) {
-			key = InvalidRegexp.ReplaceAllString(key, "_")
-		}
+					// Replace the invalid path chars such as slashes with underscores
+					path := InvalidRegexp.ReplaceAllString(config.StringVal(pc.Path), "_")
 
-		if config.BoolVal(r.config.Upcase) {
-			key = strings.ToUpper(key)
-		}
+					// Prefix the key value with the path value.
+					before := key
+					key = fmt.Sprintf("%s_%s", path, key)
+					origin.transform("prefix", before, key)
+				}
 
-		logger := namedLogger("runner")
-		if current, ok := env[key]; ok {
-			logger.Debug(fmt.Sprintf("overwriting %s=%q (was %q) from %s", key, value, current, d))
-			env[key] = value
-		} else {
-			logger.Debug(fmt.Sprintf("setting %s=%q from %s", key, value, d))
-			env[key] = value
+				// If the user specified a custom format, apply that here.
+				if config.StringPresent(cp.Format) {
+					before := key
+					key, err = applyFormatTemplate(config.StringVal(cp.Format), sourcePath, key)
+					if err != nil {
+						return err
+					}
+					origin.transform("format", before, key)
+				}
+
+				if config.BoolVal(r.config.Sanitize) {
+					before := key
+					key = InvalidRegexp.ReplaceAllString(key, "_")
+					origin.transform("sanitize", before, key)
+				}
+
+				if config.BoolVal(r.config.Upcase) {
+					before := key
+					key = strings.ToUpper(key)
+					origin.transform("upcase", before, key)
+				}
+
+				if current, ok := env[key]; ok {
+					replace, err := r.resolveConflict(key, current, value,
+						r.origins[d.String()][key], origin)
+					if err != nil {
+						return err
+					}
+					if !replace {
+						continue
+					}
+					logger.Debug(fmt.Sprintf("overwriting %s=%q (was %q) from %s", key,
+						r.redact(key, value), r.redact(key, current), d))
+					env[key] = value
+				} else {
+					logger.Debug(fmt.Sprintf("setting %s=%q from %s", key, r.redact(key, value), d))
+					env[key] = value
+				}
+				r.setOrigin(d, key, origin)
+			}
 		}
 	}
 
@@ -611,7 +1341,6 @@ func isVaultKv2(data map[string]interface{}) bool {
 func (r *Runner) appendSecrets(
 	env map[string]string, d *dep.VaultReadQuery, data interface{},
 ) error {
-	var err error
 	logger := namedLogger("runner")
 
 	typed, ok := data.(*dep.Secret)
@@ -622,7 +1351,14 @@ func (r *Runner) appendSecrets(
 	// Get the PrefixConfig so we can get configuration from it.
 	cp := r.configPrefixMap[d.String()]
 
+	// This is the path available to format templates.
+	sourcePath, err := applyPathTemplate(config.StringVal(cp.Path))
+	if err != nil {
+		return err
+	}
+
 	valueMap := typed.Data
+	version := secretVersion(typed)
 	if isVaultKv2(valueMap) {
 		// Vault Secrets KV1 and KV2 return different formats. Here we check the key
 		// value, and if we've found another key called "data" that is of type
@@ -655,104 +1391,153 @@ func (r *Runner) appendSecrets(
 		}
 	}
 
-	var applyPerKeyFormat bool
-	var keyFormats map[string][]*KeyFormat
-
-	// pre-populate key formats map here so we don't have a potential O(n^2) complexity in the loop later
-	if cp.Keys != nil && !config.StringPresent(cp.Format) {
-		applyPerKeyFormat = true
-		keyFormats = make(map[string][]*KeyFormat)
-		for _, v := range *cp.Keys {
-			keyFormats[*v.Name] = append(keyFormats[*v.Name], v)
+	// When rendering to files, the child only gets the path to the directory,
+	// which is not a secret itself.
+	files := cp.Files
+	if files.Enabled() {
+		key, dir := config.StringVal(files.Env), config.StringVal(files.Dir)
+		origin := &keyOrigin{
+			Source:     d.String(),
+			Version:    version,
+			Transforms: []string{"files: path to the secret files"},
+		}
+		replace := true
+		if current, ok := env[key]; ok {
+			if replace, err = r.resolveConflict(key, current, dir,
+				r.origins[d.String()][key], origin); err != nil {
+				return err
+			}
+		}
+		if replace {
+			env[key] = dir
+			r.setOrigin(d, key, origin)
 		}
 	}
 
-	for originalKey, value := range valueMap {
+	keyFormats, applyPerKeyFormat := perKeyFormats(cp)
+
+	// Keys are handled in order, so that keys which are the same after being
+	// transformed resolve the same way on every run.
+	for _, rootKey := range sortedKeys(valueMap) {
+		rootValue := valueMap[rootKey]
+
 		// Ignore any keys that are empty (not sure if this is even possible in
 		// Vault, but I play defense).
-		if strings.TrimSpace(originalKey) == "" {
+		if strings.TrimSpace(rootKey) == "" {
 			continue
 		}
 
 		// Ignore any keys in which value is nil
-		if value == nil {
+		if rootValue == nil {
 			continue
 		}
 
-		keys := []string{originalKey}
-		// Check for per-key configuration override on a very early stage
-		// before the `key` is updated with prefix or become uppercase
-		if applyPerKeyFormat {
-			keyFormat, ok := keyFormats[originalKey]
-			if !ok {
-				logger.Debug(fmt.Sprintf("skipping key '%s' since it is not listed in configuration", originalKey))
-				continue
-			}
-			appliedFormats := []string{}
-			for _, format := range keyFormat {
-				if config.StringPresent(format.Format) {
-					key, err := applyFormatTemplate(*format.Format, originalKey)
-					if err != nil {
-						return err
-					}
-					appliedFormats = append(appliedFormats, key)
-				}
-			}
-			// reset keys slice in case of per-key formatting
-			if len(appliedFormats) > 0 {
-				keys = appliedFormats
+		values, err := encodeValue(rootKey, rootValue,
+			config.StringVal(cp.Encoding), config.StringVal(cp.Separator))
+		if err != nil {
+			if config.BoolVal(cp.Strict) {
+				return fmt.Errorf("key '%s' from %s: %w", rootKey, d, err)
 			}
+			logger.Warn(fmt.Sprintf("skipping key '%s', %s", rootKey, err))
+			continue
 		}
 
-		for i := range keys {
-			key := keys[i]
-			// NoPrefix is nil when not set in config. Default to including prefix for Vault secrets.
-			if cp.NoPrefix == nil || !config.BoolVal(cp.NoPrefix) {
-				// Replace the path slashes with an underscore.
-				pc, ok := r.configPrefixMap[d.String()]
+		for _, originalKey := range sortedKeys(values) {
+			val := values[originalKey]
+			keys := []string{originalKey}
+			// Check for per-key configuration override on a very early stage
+			// before the `key` is updated with prefix or become uppercase.
+			// Flattened keys also match the configuration of their root key.
+			if applyPerKeyFormat {
+				keyFormat, ok := keyFormats[originalKey]
 				if !ok {
-					return fmt.Errorf("missing dependency %s", d)
+					keyFormat, ok = keyFormats[rootKey]
 				}
-
-				path, err := applyPathTemplate(config.StringVal(pc.Path))
-				if err != nil {
+				if !ok {
+					logger.Debug(fmt.Sprintf("skipping key '%s' since it is not listed in configuration", originalKey))
+					continue
+				}
+				if keys, err = applyKeyFormats(keyFormat, sourcePath, originalKey); err != nil {
 					return err
 				}
-				path = InvalidRegexp.ReplaceAllString(path, "_")
-
-				// Prefix the key value with the path value.
-				key = fmt.Sprintf("%s_%s", path, key)
 			}
 
-			// If the user specified a custom format for all keys, apply that here.
-			if config.StringPresent(cp.Format) {
-				key, err = applyFormatTemplate(config.StringVal(cp.Format), key)
-				if err != nil {
-					return err
+			for i := range keys {
+				key := keys[i]
+				origin := &keyOrigin{
+					Source:  d.String(),
+					Version: version,
+					RawKey:  originalKey,
+					secret:  true,
 				}
-			}
+				origin.transform("key format", originalKey, key)
+
+				// NoPrefix is nil when not set in config. Default to including prefix for Vault secrets.
+				if cp.NoPrefix == nil || !config.BoolVal(cp.NoPrefix) {
+					// Replace the path slashes with an underscore.
+					pc, ok := r.configPrefixMap[d.String()]
+					if !ok {
+						return fmt.Errorf("missing dependency %s", d)
+					}
 
-			if config.BoolVal(r.config.Sanitize) {
-				key = InvalidRegexp.ReplaceAllString(key, "_")
-			}
+					path, err := applyPathTemplate(config.StringVal(pc.Path))
+					if err != nil {
+						return err
+					}
+					path = InvalidRegexp.ReplaceAllString(path, "_")
 
-			if config.BoolVal(r.config.Upcase) {
-				key = strings.ToUpper(key)
-			}
+					// Prefix the key value with the path value.
+					before := key
+					key = fmt.Sprintf("%s_%s", path, key)
+					origin.transform("prefix", before, key)
+				}
 
-			val, ok := value.(string)
-			if !ok {
-				logger.Warn(fmt.Sprintf("skipping key '%s', invalid type for value. got %v, not string", key, reflect.TypeOf(value)))
-				continue
-			}
+				// If the user specified a custom format for all keys, apply that here.
+				if config.StringPresent(cp.Format) {
+					before := key
+					key, err = applyFormatTemplate(config.StringVal(cp.Format), sourcePath, key)
+					if err != nil {
+						return err
+					}
+					origin.transform("format", before, key)
+				}
 
-			if _, ok := env[key]; ok {
-				logger.Debug(fmt.Sprintf("overwriting %s from %s", key, d))
-			} else {
-				logger.Debug(fmt.Sprintf("setting %s from %s", key, d))
-			}
+				if config.BoolVal(r.config.Sanitize) {
+					before := key
+					key = InvalidRegexp.ReplaceAllString(key, "_")
+					origin.transform("sanitize", before, key)
+				}
+
+				if config.BoolVal(r.config.Upcase) {
+					before := key
+					key = strings.ToUpper(key)
+					origin.transform("upcase", before, key)
+				}
+
+				if files.Enabled() {
+					if err := r.addSecretFile(files, key, val, origin); err != nil {
+						return err
+					}
+					continue
+				}
 
-			env[key] = val
+				if current, ok := env[key]; ok {
+					replace, err := r.resolveConflict(key, current, val,
+						r.origins[d.String()][key], origin)
+					if err != nil {
+						return err
+					}
+					if !replace {
+						continue
+					}
+					logger.Debug(fmt.Sprintf("overwriting %s from %s", key, d))
+				} else {
+					logger.Debug(fmt.Sprintf("setting %s from %s", key, d))
+				}
+
+				env[key] = val
+				r.setOrigin(d, key, origin)
+			}
 		}
 	}
 
@@ -774,6 +1559,35 @@ func (r *Runner) init(clients *dep.ClientSet) error {
 	logger := namedLogger("runner")
 	logger.Debug("final config:", string(result))
 
+	// The configuration is checked by the same validation as the validate
+	// command, which reports every problem at once.
+	if err := r.config.Validate(); err != nil {
+		return err
+	}
+	for _, w := range r.config.Warnings() {
+		logger.Warn(w)
+	}
+
+	r.supervisor = newSupervisor(r.config.Supervise)
+	r.redactor = newRedactor(r.config)
+
+	if r.config.Cache.Enabled() {
+		if r.cacheKey, err = loadCacheKey(r.config.Cache); err != nil {
+			return err
+		}
+		if r.cacheKey == nil && len(*r.config.Secrets) > 0 {
+			logger.Warn("no cache key is set, secrets will not be cached")
+		}
+	}
+
+	for _, c := range *r.config.Require {
+		req, err := newRequirement(c)
+		if err != nil {
+			return err
+		}
+		r.requirements = append(r.requirements, req)
+	}
+
 	// Set's consul-template's default vault lease duration and renewal thresh
 	// these will go away with hashicat as it will eliminate the setting
 	dep.SetVaultDefaultLeaseDuration(config.TimeDurationVal(r.config.Vault.DefaultLeaseDuration))
@@ -792,16 +1606,25 @@ func (r *Runner) init(clients *dep.ClientSet) error {
 		if err != nil {
 			return err
 		}
+		r.addChangeStrategy(d, p.ChangeStrategy)
 		r.dependencies = append(r.dependencies, d)
 		r.configPrefixMap[d.String()] = p
 	}
 
 	// Parse and add consul services
 	for _, s := range *r.config.Services {
-		d, err := dep.NewCatalogServiceQuery(config.StringVal(s.Query))
+		var d dep.Dependency
+		var err error
+		if health := config.StringVal(s.Health); health != "" {
+			d, err = dep.NewHealthServiceQuery(
+				config.StringVal(s.Query) + "|" + serviceHealthFilter(health))
+		} else {
+			d, err = dep.NewCatalogServiceQuery(config.StringVal(s.Query))
+		}
 		if err != nil {
 			return err
 		}
+		r.addChangeStrategy(d, s.ChangeStrategy)
 
 		r.dependencies = append(r.dependencies, d)
 		r.configServiceMap[d.String()] = s
@@ -810,7 +1633,8 @@ func (r *Runner) init(clients *dep.ClientSet) error {
 	// Parse and add vault dependencies - it is important that this come after
 	// consul, because consul should never be permitted to overwrite values from
 	// vault; that would expose a security hole since access to consul is
-	// typically less controlled than access to vault.
+	// typically less controlled than access to vault. Only an explicit priority
+	// can change this order.
 	for _, s := range *r.config.Secrets {
 		path, err := applyPathTemplate(config.StringVal(s.Path))
 		if err != nil {
@@ -822,13 +1646,43 @@ func (r *Runner) init(clients *dep.ClientSet) error {
 		if err != nil {
 			return err
 		}
+		r.addChangeStrategy(d, s.ChangeStrategy)
 		r.dependencies = append(r.dependencies, d)
 		r.configPrefixMap[d.String()] = s
 	}
 
+	r.sortDependencies()
+
 	return nil
 }
 
+// priority returns the configured priority of the dependency.
+func (r *Runner) priority(d dep.Dependency) int {
+	if p, ok := r.configPrefixMap[d.String()]; ok {
+		return config.IntVal(p.Priority)
+	}
+	if s, ok := r.configServiceMap[d.String()]; ok {
+		return config.IntVal(s.Priority)
+	}
+	return 0
+}
+
+// sortDependencies orders the dependencies by priority, keeping the order of
+// dependencies with the same priority, so that values from dependencies with a
+// higher priority are merged later and take precedence.
+func (r *Runner) sortDependencies() {
+	sort.SliceStable(r.dependencies, func(i, j int) bool {
+		return r.priority(r.dependencies[i]) < r.priority(r.dependencies[j])
+	})
+
+	order := make([]string, 0, len(r.dependencies))
+	for _, d := range r.dependencies {
+		order = append(order, fmt.Sprintf("%s (priority %d)", d, r.priority(d)))
+	}
+	namedLogger("runner").Debug("merge order, from lowest to highest precedence: " +
+		strings.Join(order, ", "))
+}
+
 func (r *Runner) stopWatchers() {
 	if r.watcher != nil {
 		namedLogger("runner").Debug("stopping watcher")
@@ -848,6 +1702,7 @@ func (r *Runner) stopChild() {
 		namedLogger("runner").Debug("stopping child process")
 		r.child.Stop()
 	}
+	r.mask.Flush()
 }
 
 // storePid is used to write out a PID file to disk.
@@ -897,6 +1752,179 @@ func (r *Runner) deletePid() error {
 	return nil
 }
 
+// secretFile is a single secret value to render to disk, and where it came
+// from.
+type secretFile struct {
+	contents []byte
+	config   *FilesConfig
+	origin   *keyOrigin
+}
+
+// addSecretFile queues the value to be written to a file named after the key
+// in the configured directory. Files set to different values by two keys are
+// resolved by the conflict policy, like keys of the environment.
+func (r *Runner) addSecretFile(c *FilesConfig, key, value string, origin *keyOrigin) error {
+	// The key must stay within the directory.
+	if key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		namedLogger("runner").Warn(fmt.Sprintf("skipping key '%s' from %s, "+
+			"not a valid file name", key, origin.Source))
+		return nil
+	}
+
+	path := filepath.Join(config.StringVal(c.Dir), key)
+	if current, ok := r.secretFiles[path]; ok {
+		replace, err := r.resolveConflict(path, string(current.contents), value,
+			current.origin, origin)
+		if err != nil {
+			return err
+		}
+		if !replace {
+			return nil
+		}
+		namedLogger("runner").Debug(fmt.Sprintf("overwriting file %s from %s", path, origin.Source))
+	} else {
+		namedLogger("runner").Debug(fmt.Sprintf("setting file %s from %s", path, origin.Source))
+	}
+	r.secretFiles[path] = &secretFile{contents: []byte(value), config: c, origin: origin}
+	return nil
+}
+
+// writeSecretFiles atomically writes the secret files compiled during the
+// current run and removes any files from previous runs that are no longer
+// needed. Files whose contents did not change are left untouched.
+func (r *Runner) writeSecretFiles() error {
+	r.renderedFilesLock.Lock()
+	defer r.renderedFilesLock.Unlock()
+
+	for path, f := range r.secretFiles {
+		if err := mkdirSecretDir(filepath.Dir(path), f.config); err != nil {
+			return err
+		}
+		result, err := renderer.Render(&renderer.RenderInput{
+			Contents: f.contents,
+			Path:     path,
+			Perms:    config.FileModeVal(f.config.Perms),
+			User:     config.StringVal(f.config.User),
+			Group:    config.StringVal(f.config.Group),
+		})
+		if err != nil {
+			return err
+		}
+		if result.DidRender {
+			namedLogger("runner").Info("rendered secret file", "path", path)
+		}
+		r.renderedFiles[path] = struct{}{}
+	}
+
+	for path := range r.renderedFiles {
+		if _, ok := r.secretFiles[path]; ok {
+			continue
+		}
+		namedLogger("runner").Info("removing secret file", "path", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(r.renderedFiles, path)
+	}
+
+	return nil
+}
+
+// mkdirSecretDir creates dir, along with any missing parents, with
+// permissions 0700. The directories it creates are owned by the user and group
+// of the files, like the files themselves, so the child process can traverse
+// them. Existing directories are left as they are.
+func mkdirSecretDir(dir string, c *FilesConfig) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); !os.IsNotExist(err) {
+			break
+		}
+		created = append(created, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	uid, err := lookupID(config.StringVal(c.User), func(s string) (string, error) {
+		u, err := user.Lookup(s)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "looking up user")
+	}
+	gid, err := lookupID(config.StringVal(c.Group), func(s string) (string, error) {
+		g, err := user.LookupGroup(s)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "looking up group")
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	for _, d := range created {
+		if err := os.Chown(d, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupID returns the numeric id of the given user or group name or id, or
+// -1 when it is empty, which leaves the owner unchanged.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// applySecrets writes the secret files compiled during the current run and
+// masks the current values of secrets in the output of the child process.
+func (r *Runner) applySecrets(env map[string]string) error {
+	r.setMaskValues(env)
+	if err := r.writeSecretFiles(); err != nil {
+		return errors.Wrap(err, "writing secret files")
+	}
+	return nil
+}
+
+// removeSecretFiles removes all secret files written by this runner.
+func (r *Runner) removeSecretFiles() {
+	r.renderedFilesLock.Lock()
+	defer r.renderedFilesLock.Unlock()
+
+	for path := range r.renderedFiles {
+		namedLogger("runner").Debug("removing secret file", "path", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			namedLogger("runner").Warn(fmt.Sprintf("could not remove secret file %s: %s", path, err))
+		}
+		delete(r.renderedFiles, path)
+	}
+}
+
 // newClientSet creates a new client set from the given config.
 func newClientSet(c *Config) (*dep.ClientSet, error) {
 	clients := dep.NewClientSet()
@@ -974,6 +2002,17 @@ func newWatcher(c *Config, clients *dep.ClientSet, once bool) *watch.Watcher {
 	})
 }
 
+// anyGlobMatch is a helper function which checks if any of the given globs
+// match the string.
+func anyGlobMatch(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // applyConfigEnv applies custom env variables and allowlist/denylist rules from config
 func (r *Runner) applyConfigEnv(env map[string]string) map[string]string {
 	// Parse custom environment variables
@@ -999,17 +2038,6 @@ func (r *Runner) applyConfigEnv(env map[string]string) map[string]string {
 		keys[k] = true
 	}
 
-	// anyGlobMatch is a helper function which checks if any of the given globs
-	// match the string.
-	anyGlobMatch := func(s string, patterns []string) bool {
-		for _, pattern := range patterns {
-			if matched, _ := filepath.Match(pattern, s); matched {
-				return true
-			}
-		}
-		return false
-	}
-
 	// Filter to envvars that match the allowlist
 	// Combining lists on each reference may be slightly inefficient but this
 	// allows for out of order method calls, not requiring the config to be
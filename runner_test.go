@@ -4,7 +4,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -821,6 +824,353 @@ func TestRunner_appendServices(t *testing.T) {
 	}
 }
 
+func TestRunner_appendServicesInstances(t *testing.T) {
+	t.Parallel()
+
+	data := []*dependency.CatalogService{
+		{
+			Node:           "node-b",
+			Address:        "10.0.0.2",
+			ServiceID:      "web-b",
+			ServiceName:    "web",
+			ServiceAddress: "",
+			ServicePort:    8080,
+		},
+		{
+			Node:           "node-a",
+			Address:        "10.0.0.1",
+			ServiceID:      "web-a",
+			ServiceName:    "web",
+			ServiceAddress: "192.168.0.1",
+			ServicePort:    8081,
+		},
+	}
+
+	cases := []struct {
+		name    string
+		service *ServiceConfig
+		data    []*dependency.CatalogService
+		exp     map[string]string
+	}{
+		{
+			"select_first",
+			&ServiceConfig{
+				Select: config.String(ServiceSelectFirst),
+			},
+			data,
+			map[string]string{
+				"WEB_ID":      "web-b",
+				"WEB_NAME":    "web",
+				"WEB_ADDRESS": "",
+				"WEB_TAG":     "",
+				"WEB_PORT":    "8080",
+			},
+		},
+		{
+			"select_node",
+			&ServiceConfig{
+				Select: config.String(ServiceSelectNode),
+			},
+			data,
+			map[string]string{
+				"WEB_ID":      "web-a",
+				"WEB_NAME":    "web",
+				"WEB_ADDRESS": "192.168.0.1",
+				"WEB_TAG":     "",
+				"WEB_PORT":    "8081",
+			},
+		},
+		{
+			"indexed_aggregate",
+			&ServiceConfig{
+				FormatId:      config.String("{{ service }}/single_id"),
+				FormatName:    config.String("{{ service }}/single_name"),
+				FormatAddress: config.String("{{ service }}/single_address"),
+				FormatTag:     config.String("{{ service }}/single_tag"),
+				FormatPort:    config.String("{{ service }}/single_port"),
+				Indexed:       config.Bool(true),
+				Aggregate:     config.Bool(true),
+			},
+			data,
+			map[string]string{
+				"WEB_SINGLE_ID":      "web-a",
+				"WEB_SINGLE_NAME":    "web",
+				"WEB_SINGLE_ADDRESS": "192.168.0.1",
+				"WEB_SINGLE_TAG":     "",
+				"WEB_SINGLE_PORT":    "8081",
+				"WEB_0_ID":           "web-b",
+				"WEB_0_NAME":         "web",
+				"WEB_0_ADDRESS":      "",
+				"WEB_0_TAG":          "",
+				"WEB_0_PORT":         "8080",
+				"WEB_1_ID":           "web-a",
+				"WEB_1_NAME":         "web",
+				"WEB_1_ADDRESS":      "192.168.0.1",
+				"WEB_1_TAG":          "",
+				"WEB_1_PORT":         "8081",
+				"WEB_COUNT":          "2",
+				"WEB_ADDRESSES":      "10.0.0.2:8080,192.168.0.1:8081",
+			},
+		},
+		{
+			"custom_formats",
+			&ServiceConfig{
+				Indexed:         config.Bool(true),
+				FormatIndexed:   config.String("{{ key }}_{{ service }}_{{ index }}"),
+				FormatCount:     config.String("num_{{ service }}"),
+				FormatId:        config.String("skip"),
+				FormatName:      config.String("skip"),
+				FormatAddress:   config.String("skip"),
+				FormatTag:       config.String("skip"),
+				FormatPort:      config.String("skip"),
+				Aggregate:       config.Bool(true),
+				FormatAddresses: config.String("{{ service }}_hosts"),
+			},
+			data[:1],
+			map[string]string{
+				"SKIP":          "8080",
+				"ID_WEB_0":      "web-b",
+				"NAME_WEB_0":    "web",
+				"ADDRESS_WEB_0": "",
+				"TAG_WEB_0":     "",
+				"PORT_WEB_0":    "8080",
+				"NUM_WEB":       "1",
+				"WEB_HOSTS":     "10.0.0.2:8080",
+			},
+		},
+		{
+			"no_instances",
+			&ServiceConfig{
+				Indexed:   config.Bool(true),
+				Aggregate: config.Bool(true),
+			},
+			nil,
+			map[string]string{
+				"WEB_COUNT":     "0",
+				"WEB_ADDRESSES": "",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.service.Query = config.String("tag.web@dc1")
+			c := DefaultConfig().Merge(&Config{
+				Upcase:   config.Bool(true),
+				Sanitize: config.Bool(true),
+				Services: &ServiceConfigs{tc.service},
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			csq, err := dependency.NewCatalogServiceQuery("tag.web@dc1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := make(map[string]string)
+			if err := r.appendServices(env, csq, tc.data); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.exp, env) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, env)
+			}
+		})
+	}
+
+	t.Run("select_random", func(t *testing.T) {
+		c := DefaultConfig().Merge(&Config{
+			Services: &ServiceConfigs{&ServiceConfig{
+				Query:  config.String("web"),
+				Select: config.String(ServiceSelectRandom),
+			}},
+		})
+		r, err := NewRunner(c, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csq, err := dependency.NewCatalogServiceQuery("web")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The pick is kept for as long as the instance is in the catalog.
+		env := make(map[string]string)
+		if err := r.appendServices(env, csq, data); err != nil {
+			t.Fatal(err)
+		}
+		picked := env["web/id"]
+		for i := 0; i < 10; i++ {
+			env := make(map[string]string)
+			if err := r.appendServices(env, csq, data); err != nil {
+				t.Fatal(err)
+			}
+			if env["web/id"] != picked {
+				t.Fatalf("expected %q to stay selected, got %q", picked, env["web/id"])
+			}
+		}
+	})
+}
+
+func TestRunner_appendServicesHealth(t *testing.T) {
+	t.Parallel()
+
+	data := []*dependency.HealthService{
+		{
+			Node:                "node-a",
+			NodeAddress:         "10.0.0.1",
+			NodeTaggedAddresses: map[string]string{"lan": "10.0.0.1", "wan": "1.2.3.4"},
+			ServiceMeta:         map[string]string{"version": "1.2"},
+			Address:             "10.0.0.1",
+			ID:                  "web-a",
+			Name:                "web",
+			Tags:                dependency.ServiceTags{"blue"},
+			Status:              "warning",
+			Port:                8080,
+		},
+	}
+
+	cases := []struct {
+		name    string
+		service *ServiceConfig
+		exp     map[string]string
+	}{
+		{
+			"default",
+			&ServiceConfig{},
+			map[string]string{
+				"web/id":      "web-a",
+				"web/name":    "web",
+				"web/address": "10.0.0.1",
+				"web/tag":     "blue",
+				"web/port":    "8080",
+			},
+		},
+		{
+			"include",
+			&ServiceConfig{
+				Include: []string{"status", "meta", "node", "tagged_addresses"},
+			},
+			map[string]string{
+				"web/id":                   "web-a",
+				"web/name":                 "web",
+				"web/address":              "10.0.0.1",
+				"web/tag":                  "blue",
+				"web/port":                 "8080",
+				"web/status":               "warning",
+				"web/meta/version":         "1.2",
+				"web/node":                 "node-a",
+				"web/tagged_addresses/lan": "10.0.0.1",
+				"web/tagged_addresses/wan": "1.2.3.4",
+			},
+		},
+		{
+			"include_formats",
+			&ServiceConfig{
+				FormatId:            config.String("id"),
+				FormatName:          config.String("name"),
+				FormatAddress:       config.String("address"),
+				FormatTag:           config.String("tag"),
+				FormatPort:          config.String("port"),
+				Include:             []string{"status", "meta", "node", "tagged_addresses"},
+				FormatStatus:        config.String("health"),
+				FormatMeta:          config.String("meta_{{ key }}"),
+				FormatNode:          config.String("host"),
+				FormatTaggedAddress: config.String("{{ key }}_addr"),
+			},
+			map[string]string{
+				"id":           "web-a",
+				"name":         "web",
+				"address":      "10.0.0.1",
+				"tag":          "blue",
+				"port":         "8080",
+				"health":       "warning",
+				"meta_version": "1.2",
+				"host":         "node-a",
+				"lan_addr":     "10.0.0.1",
+				"wan_addr":     "1.2.3.4",
+			},
+		},
+		{
+			"include_indexed",
+			&ServiceConfig{
+				FormatId:      config.String("id"),
+				FormatName:    config.String("name"),
+				FormatAddress: config.String("address"),
+				FormatTag:     config.String("tag"),
+				FormatPort:    config.String("port"),
+				FormatStatus:  config.String("status"),
+				FormatMeta:    config.String("meta"),
+				Include:       []string{"status", "meta"},
+				Indexed:       config.Bool(true),
+			},
+			map[string]string{
+				"id":                 "web-a",
+				"name":               "web",
+				"address":            "10.0.0.1",
+				"tag":                "blue",
+				"port":               "8080",
+				"status":             "warning",
+				"meta":               "1.2",
+				"web/0/id":           "web-a",
+				"web/0/name":         "web",
+				"web/0/address":      "10.0.0.1",
+				"web/0/tag":          "blue",
+				"web/0/port":         "8080",
+				"web/0/status":       "warning",
+				"web/0/meta/version": "1.2",
+				"web/count":          "1",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.service.Query = config.String("web")
+			tc.service.Health = config.String(ServiceHealthWarning)
+			c := DefaultConfig().Merge(&Config{
+				Services: &ServiceConfigs{tc.service},
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(r.dependencies) != 1 {
+				t.Fatalf("expected 1 dependency, got %d", len(r.dependencies))
+			}
+			d, ok := r.dependencies[0].(*dependency.HealthServiceQuery)
+			if !ok {
+				t.Fatalf("expected health query, got %T", r.dependencies[0])
+			}
+			if exp := "health.service(web|passing,warning)"; d.String() != exp {
+				t.Errorf("expected %q, got %q", exp, d.String())
+			}
+
+			env := make(map[string]string)
+			if err := r.appendServices(env, d, data); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.exp, env) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.exp, env)
+			}
+		})
+	}
+
+	t.Run("status_requires_health", func(t *testing.T) {
+		c := DefaultConfig().Merge(&Config{
+			Services: &ServiceConfigs{&ServiceConfig{
+				Query:   config.String("web"),
+				Include: []string{ServiceIncludeStatus},
+			}},
+		})
+		if _, err := NewRunner(c, true); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
 func TestRunner_configEnv(t *testing.T) {
 	t.Parallel()
 
@@ -959,3 +1309,555 @@ func TestRunner_configEnvDeprecated(t *testing.T) {
 		})
 	}
 }
+
+func TestRunner_secretFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "secrets")
+
+	cfg := Config{
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{
+				Path:     config.String("kv/foo"),
+				NoPrefix: config.Bool(true),
+				Files: &FilesConfig{
+					Dir: config.String(dir),
+					Env: config.String("FOO_SECRETS"),
+				},
+			},
+		},
+	}
+	c := DefaultConfig().Merge(&cfg)
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrq, err := dependency.NewVaultReadQuery("kv/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := make(map[string]string)
+	err = r.appendSecrets(env, vrq, &dependency.Secret{
+		Data: map[string]interface{}{
+			"bar": "somevalue1",
+			"zed": "somevalue2",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expEnv := map[string]string{"FOO_SECRETS": dir}
+	if !reflect.DeepEqual(expEnv, env) {
+		t.Errorf("\nexp: %#v\nact: %#v", expEnv, env)
+	}
+
+	if err := r.writeSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	for key, exp := range map[string]string{"bar": "somevalue1", "zed": "somevalue2"} {
+		path := filepath.Join(dir, key)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != exp {
+			t.Errorf("bad contents for %s: %q", key, string(b))
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != DefaultFilesPerms {
+			t.Errorf("bad perms for %s: %s", key, stat.Mode())
+		}
+	}
+
+	// A key removed from Vault removes the file on the next run.
+	r.secretFiles = make(map[string]*secretFile)
+	err = r.appendSecrets(make(map[string]string), vrq, &dependency.Secret{
+		Data: map[string]interface{}{
+			"bar": "changed",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.writeSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "bar")); err != nil || string(b) != "changed" {
+		t.Errorf("expected bar to be rewritten, got %q (%v)", string(b), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "zed")); !os.IsNotExist(err) {
+		t.Errorf("expected zed to be removed, got %v", err)
+	}
+
+	r.removeSecretFiles()
+	if _, err := os.Stat(filepath.Join(dir, "bar")); !os.IsNotExist(err) {
+		t.Errorf("expected bar to be removed, got %v", err)
+	}
+}
+
+func TestRunner_appendSecretsEncoding(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]interface{}{
+		"str":   "value",
+		"num":   json.Number("42"),
+		"float": float64(1000000),
+		"bool":  true,
+		"list":  []interface{}{"a", json.Number("1")},
+		"db": map[string]interface{}{
+			"host": "localhost",
+			"port": json.Number("5432"),
+			"opts": map[string]interface{}{
+				"ssl": false,
+			},
+		},
+	}
+
+	cases := []struct {
+		name      string
+		encoding  string
+		separator string
+		strict    bool
+		keys      *KeyFormats
+		expected  map[string]string
+		err       bool
+	}{
+		{
+			name:     "string skips non strings",
+			encoding: ValueEncodingString,
+			expected: map[string]string{
+				"str": "value",
+			},
+		},
+		{
+			name:     "string strict fails",
+			encoding: ValueEncodingString,
+			strict:   true,
+			err:      true,
+		},
+		{
+			name:     "json",
+			encoding: ValueEncodingJSON,
+			strict:   true,
+			expected: map[string]string{
+				"str":   "value",
+				"num":   "42",
+				"float": "1000000",
+				"bool":  "true",
+				"list":  `["a",1]`,
+				"db":    `{"host":"localhost","opts":{"ssl":false},"port":5432}`,
+			},
+		},
+		{
+			name:     "flatten",
+			encoding: ValueEncodingFlatten,
+			expected: map[string]string{
+				"str":         "value",
+				"num":         "42",
+				"float":       "1000000",
+				"bool":        "true",
+				"list":        `["a",1]`,
+				"db_host":     "localhost",
+				"db_port":     "5432",
+				"db_opts_ssl": "false",
+			},
+		},
+		{
+			name:      "flatten with separator",
+			encoding:  ValueEncodingFlatten,
+			separator: "__",
+			keys: &KeyFormats{
+				&KeyFormat{Name: config.String("db")},
+			},
+			expected: map[string]string{
+				"db__host":      "localhost",
+				"db__port":      "5432",
+				"db__opts__ssl": "false",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &PrefixConfig{
+				Path:     config.String("kv/foo"),
+				NoPrefix: config.Bool(true),
+				Encoding: config.String(tc.encoding),
+				Strict:   config.Bool(tc.strict),
+				Keys:     tc.keys,
+			}
+			if tc.separator != "" {
+				p.Separator = config.String(tc.separator)
+			}
+			c := DefaultConfig().Merge(&Config{Secrets: &PrefixConfigs{p}})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vrq, err := dependency.NewVaultReadQuery("kv/foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := make(map[string]string)
+			err = r.appendSecrets(env, vrq, &dependency.Secret{Data: data})
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if tc.err {
+				return
+			}
+			if !reflect.DeepEqual(tc.expected, env) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.expected, env)
+			}
+		})
+	}
+}
+
+func TestRunner_appendPrefixesDecode(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		config   *PrefixConfig
+		data     []*dependency.KeyPair
+		upcase   bool
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name: "json document at prefix",
+			config: &PrefixConfig{
+				Decode: config.String(DecodeJSON),
+			},
+			data: []*dependency.KeyPair{
+				{Key: "", Value: `{"db": {"host": "localhost", "port": 5432}, "debug": true}`},
+			},
+			expected: map[string]string{
+				"db_host": "localhost",
+				"db_port": "5432",
+				"debug":   "true",
+			},
+		},
+		{
+			name: "yaml documents in folder",
+			config: &PrefixConfig{
+				Decode:    config.String(DecodeYAML),
+				Separator: config.String("__"),
+			},
+			data: []*dependency.KeyPair{
+				{Key: "web", Value: "port: 80\nhosts:\n  - a\n  - b\n"},
+				{Key: "sub/", Value: ""},
+			},
+			upcase: true,
+			expected: map[string]string{
+				"WEB__PORT":  "80",
+				"WEB__HOSTS": `["a","b"]`,
+			},
+		},
+		{
+			name: "format and prefix are applied",
+			config: &PrefixConfig{
+				Decode:   config.String(DecodeJSON),
+				NoPrefix: config.Bool(false),
+				Format:   config.String("app_{{ key }}"),
+			},
+			data: []*dependency.KeyPair{
+				{Key: "cfg", Value: `{"a": "b"}`},
+			},
+			expected: map[string]string{
+				"app_app_my_service_cfg_a": "b",
+			},
+		},
+		{
+			name: "invalid document is skipped",
+			config: &PrefixConfig{
+				Decode: config.String(DecodeJSON),
+			},
+			data: []*dependency.KeyPair{
+				{Key: "bad", Value: `{`},
+				{Key: "good", Value: `{"a": "b"}`},
+			},
+			expected: map[string]string{
+				"good_a": "b",
+			},
+		},
+		{
+			name: "invalid document fails in strict mode",
+			config: &PrefixConfig{
+				Decode: config.String(DecodeJSON),
+				Strict: config.Bool(true),
+			},
+			data: []*dependency.KeyPair{
+				{Key: "bad", Value: `{`},
+			},
+			err: true,
+		},
+		{
+			name: "required decoded key is present",
+			config: &PrefixConfig{
+				Decode: config.String(DecodeJSON),
+				Keys: &KeyFormats{
+					&KeyFormat{Name: config.String("config_db_host"), Required: config.Bool(true)},
+				},
+			},
+			data: []*dependency.KeyPair{
+				{Key: "config", Value: `{"db": {"host": "h"}}`},
+			},
+			expected: map[string]string{
+				"config_db_host": "h",
+			},
+		},
+		{
+			name: "required decoded key is missing",
+			config: &PrefixConfig{
+				Decode: config.String(DecodeJSON),
+				Keys: &KeyFormats{
+					&KeyFormat{Name: config.String("config_db_port"), Required: config.Bool(true)},
+				},
+			},
+			data: []*dependency.KeyPair{
+				{Key: "config", Value: `{"db": {"host": "h"}}`},
+			},
+			err: true,
+		},
+		{
+			name: "default of decoded key is not decoded",
+			config: &PrefixConfig{
+				Decode: config.String(DecodeJSON),
+				Keys: &KeyFormats{
+					&KeyFormat{Name: config.String("config_db_host")},
+					&KeyFormat{Name: config.String("config_db_user"), Default: config.String("admin")},
+				},
+			},
+			data: []*dependency.KeyPair{
+				{Key: "config", Value: `{"db": {"host": "h"}}`},
+			},
+			expected: map[string]string{
+				"config_db_host": "h",
+				"config_db_user": "admin",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.Path = config.String("app/my_service")
+			c := DefaultConfig().Merge(&Config{
+				Prefixes: &PrefixConfigs{tc.config},
+				Upcase:   config.Bool(tc.upcase),
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kvq, err := dependency.NewKVListQuery("app/my_service")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := make(map[string]string)
+			err = r.appendPrefixes(env, kvq, tc.data)
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if tc.err {
+				return
+			}
+			if !reflect.DeepEqual(tc.expected, env) {
+				t.Errorf("\nexp: %#v\nact: %#v", tc.expected, env)
+			}
+		})
+	}
+}
+
+func TestRunner_priority(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Pristine: config.Bool(true),
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("override"), Priority: config.Int(10)},
+			&PrefixConfig{Path: config.String("app")},
+		},
+		Services: &ServiceConfigs{
+			&ServiceConfig{Query: config.String("db"), Priority: config.Int(-1)},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app"), NoPrefix: config.Bool(true)},
+		},
+	})
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for _, d := range r.dependencies {
+		order = append(order, d.String())
+	}
+	exp := []string{
+		"catalog.service(db)",
+		"kv.list(app)",
+		"vault.read(secret/app)",
+		"kv.list(override)",
+	}
+	if !reflect.DeepEqual(order, exp) {
+		t.Fatalf("expected order %v, got %v", exp, order)
+	}
+
+	r.Receive(r.dependencies[0], []*dependency.CatalogService{})
+	r.Receive(r.dependencies[1], []*dependency.KeyPair{{Key: "password", Value: "consul"}})
+	r.Receive(r.dependencies[2], &dependency.Secret{
+		Data: map[string]interface{}{"password": "vault"},
+	})
+	r.Receive(r.dependencies[3], []*dependency.KeyPair{{Key: "password", Value: "override"}})
+
+	env, _, _, err := r.buildEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["password"] != "override" {
+		t.Errorf("expected the highest priority to win, got %q", env["password"])
+	}
+}
+
+func TestRunner_appendPrefixesKeys(t *testing.T) {
+	t.Parallel()
+
+	data := []*dependency.KeyPair{
+		{Key: "host", Value: "db.local"},
+		{Key: "user", Value: "app"},
+		{Key: "debug", Value: "true"},
+	}
+
+	cases := []struct {
+		name string
+		keys *KeyFormats
+		exp  map[string]string
+		err  bool
+	}{
+		{
+			"allowlist",
+			&KeyFormats{
+				&KeyFormat{Name: config.String("host")},
+			},
+			map[string]string{"host": "db.local"},
+			false,
+		},
+		{
+			"rename_and_aliases",
+			&KeyFormats{
+				&KeyFormat{Name: config.String("host"), Format: config.String("DB_{{ key | upper }}")},
+				&KeyFormat{Name: config.String("user"), Format: config.String("DB_USER")},
+				&KeyFormat{Name: config.String("user"), Format: config.String("PGUSER")},
+			},
+			map[string]string{"DB_HOST": "db.local", "DB_USER": "app", "PGUSER": "app"},
+			false,
+		},
+		{
+			"default",
+			&KeyFormats{
+				&KeyFormat{Name: config.String("host"), Default: config.String("localhost")},
+				&KeyFormat{Name: config.String("port"), Default: config.String("5432"), Format: config.String("DB_PORT")},
+			},
+			map[string]string{"host": "db.local", "DB_PORT": "5432"},
+			false,
+		},
+		{
+			"required_present",
+			&KeyFormats{
+				&KeyFormat{Name: config.String("user"), Required: config.Bool(true)},
+			},
+			map[string]string{"user": "app"},
+			false,
+		},
+		{
+			"required_missing",
+			&KeyFormats{
+				&KeyFormat{Name: config.String("user")},
+				&KeyFormat{Name: config.String("password"), Required: config.Bool(true)},
+			},
+			map[string]string{},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("app"), Keys: tc.keys},
+				},
+			})
+			r, err := NewRunner(c, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := make(map[string]string)
+			err = r.appendPrefixes(env, r.dependencies[0].(*dependency.KVListQuery), data)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(env, tc.exp) {
+				t.Errorf("expected %v, got %v", tc.exp, env)
+			}
+		})
+	}
+}
+
+func TestRunner_buildEnv_requiredKeys(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{
+				Path: config.String("app"),
+				Keys: &KeyFormats{
+					&KeyFormat{Name: config.String("password"), Required: config.Bool(true)},
+				},
+			},
+		},
+	})
+	r, err := NewRunner(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Receive(r.dependencies[0], []*dependency.KeyPair{{Key: "user", Value: "app"}})
+	if _, _, ok, err := r.buildEnv(); ok || err != nil {
+		t.Fatalf("expected the run to wait for the required key, got %t, %v", ok, err)
+	}
+	missing := r.missingDependencies()
+	if len(missing) != 1 {
+		t.Fatalf("expected 1 missing dependency, got %d", len(missing))
+	}
+	if exp := `prefix "app" (kv.list(app)): kv.list(app): missing required keys: password`; missing[0].String() != exp {
+		t.Errorf("expected %q, got %q", exp, missing[0].String())
+	}
+
+	r.Receive(r.dependencies[0], []*dependency.KeyPair{{Key: "password", Value: "hunter2"}})
+	env, _, ok, err := r.buildEnv()
+	if !ok || err != nil {
+		t.Fatalf("expected the run to continue, got %t, %v", ok, err)
+	}
+	if env["password"] != "hunter2" {
+		t.Errorf("expected the password to be set, got %v", env)
+	}
+	if missing := r.missingDependencies(); len(missing) != 0 {
+		t.Errorf("expected no missing dependencies, got %v", missing)
+	}
+
+	// In once mode, no more data is coming, so missing keys are an error.
+	r.once = true
+	r.Receive(r.dependencies[0], []*dependency.KeyPair{})
+	if _, _, _, err := r.buildEnv(); err == nil {
+		t.Error("expected an error in once mode")
+	}
+}
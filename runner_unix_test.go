@@ -0,0 +1,49 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestMkdirSecretDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a directory requires root")
+	}
+	t.Parallel()
+
+	existing := t.TempDir()
+	dir := filepath.Join(existing, "app", "secrets")
+	if err := mkdirSecretDir(dir, &FilesConfig{
+		User:  config.String("65534"),
+		Group: config.String("65534"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	owner := func(path string) (uint32, uint32) {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := stat.Sys().(*syscall.Stat_t)
+		return st.Uid, st.Gid
+	}
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		if uid, gid := owner(d); uid != 65534 || gid != 65534 {
+			t.Errorf("expected %s to be owned by 65534:65534, got %d:%d", d, uid, gid)
+		}
+	}
+	if uid, gid := owner(existing); uid != 0 || gid != 0 {
+		t.Errorf("expected %s to be left as it is, got %d:%d", existing, uid, gid)
+	}
+}
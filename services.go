@@ -0,0 +1,235 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// serviceFields are the per instance fields added to the environment, in the
+// order they are added.
+var serviceFields = []string{"id", "name", "address", "tag", "port"}
+
+// validServiceSelect returns true if the given strategy is a known service
+// selection strategy.
+func validServiceSelect(s string) bool {
+	for _, v := range ServiceSelects {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validServiceHealth returns true if the given value is a known health
+// filter, or empty to use the catalog.
+func validServiceHealth(s string) bool {
+	switch s {
+	case "", ServiceHealthPassing, ServiceHealthWarning:
+		return true
+	}
+	return false
+}
+
+// validServiceInclude returns true if the given value is a known optional
+// service variable.
+func validServiceInclude(s string) bool {
+	for _, v := range ServiceIncludes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceHealthFilter returns the health endpoint filter for the given health
+// setting.
+func serviceHealthFilter(health string) string {
+	if health == ServiceHealthWarning {
+		return dep.HealthPassing + "," + dep.HealthWarning
+	}
+	return dep.HealthPassing
+}
+
+// serviceQueryName returns the service name from a service query, which is
+// used for the count variable when there are no instances to take it from.
+func serviceQueryName(query string) string {
+	m := dep.CatalogServiceQueryRe.FindStringSubmatch(query)
+	if m == nil {
+		return query
+	}
+	return m[dep.CatalogServiceQueryRe.SubexpIndex("name")]
+}
+
+// serviceInstance is a service instance from either the catalog or the health
+// endpoint.
+type serviceInstance struct {
+	ID      string
+	Name    string
+	Address string
+	Tags    []string
+	Port    int
+
+	// Node and NodeAddress are the name and address of the node the instance
+	// is registered on. TaggedAddresses are the tagged addresses of the node.
+	Node            string
+	NodeAddress     string
+	TaggedAddresses map[string]string
+
+	// Status is the aggregated check status. It is empty for instances from
+	// the catalog.
+	Status string
+	Meta   map[string]string
+}
+
+// serviceInstances converts the data of a catalog or health service
+// dependency into service instances.
+func serviceInstances(data interface{}) ([]*serviceInstance, bool) {
+	switch typed := data.(type) {
+	case []*dep.CatalogService:
+		list := make([]*serviceInstance, 0, len(typed))
+		for _, ser := range typed {
+			list = append(list, &serviceInstance{
+				ID:              ser.ServiceID,
+				Name:            ser.ServiceName,
+				Address:         ser.ServiceAddress,
+				Tags:            ser.ServiceTags,
+				Port:            ser.ServicePort,
+				Node:            ser.Node,
+				NodeAddress:     ser.Address,
+				TaggedAddresses: ser.TaggedAddresses,
+				Meta:            ser.ServiceMeta,
+			})
+		}
+		return list, true
+	case []*dep.HealthService:
+		list := make([]*serviceInstance, 0, len(typed))
+		for _, ser := range typed {
+			list = append(list, &serviceInstance{
+				ID:              ser.ID,
+				Name:            ser.Name,
+				Address:         ser.Address,
+				Tags:            ser.Tags,
+				Port:            ser.Port,
+				Node:            ser.Node,
+				NodeAddress:     ser.NodeAddress,
+				TaggedAddresses: ser.NodeTaggedAddresses,
+				Status:          ser.Status,
+				Meta:            ser.ServiceMeta,
+			})
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// values returns the value of each of the serviceFields.
+func (s *serviceInstance) values() map[string]string {
+	return map[string]string{
+		"id":      s.ID,
+		"name":    s.Name,
+		"address": s.Address,
+		"tag":     strings.Join(s.Tags, ","),
+		"port":    strconv.Itoa(s.Port),
+	}
+}
+
+// hostPort returns the host:port of the instance. Consul leaves the service
+// address empty when the service uses the address of its node.
+func (s *serviceInstance) hostPort() string {
+	host := s.Address
+	if host == "" {
+		host = s.NodeAddress
+	}
+	return net.JoinHostPort(host, strconv.Itoa(s.Port))
+}
+
+// selectService picks the instance used for the single value variables of
+// the service with the given dependency id. The random strategy remembers its
+// pick, so the same instance is used until it leaves the catalog.
+func (r *Runner) selectService(
+	id, strategy string, services []*serviceInstance,
+) *serviceInstance {
+	if len(services) == 0 {
+		delete(r.serviceSelection, id)
+		return nil
+	}
+
+	switch strategy {
+	case ServiceSelectFirst:
+		return services[0]
+	case ServiceSelectRandom:
+		if prev, ok := r.serviceSelection[id]; ok {
+			for _, ser := range services {
+				if ser.ID == prev {
+					return ser
+				}
+			}
+		}
+		ser := services[rand.Intn(len(services))]
+		r.serviceSelection[id] = ser.ID
+		return ser
+	case ServiceSelectNode:
+		sorted := make([]*serviceInstance, len(services))
+		copy(sorted, services)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if sorted[i].Node != sorted[j].Node {
+				return sorted[i].Node < sorted[j].Node
+			}
+			return sorted[i].ID < sorted[j].ID
+		})
+		return sorted[0]
+	default:
+		return services[len(services)-1]
+	}
+}
+
+// serviceExtra is an optional variable of a service instance. Field is the
+// default key under the service name and key is the value of the key function
+// in its format.
+type serviceExtra struct {
+	field  string
+	key    string
+	value  string
+	format *string
+}
+
+// extras returns the optional variables of the instance which are included by
+// the given service configuration.
+func (s *serviceInstance) extras(cs *ServiceConfig) []serviceExtra {
+	var list []serviceExtra
+	for _, include := range cs.Include {
+		switch include {
+		case ServiceIncludeStatus:
+			list = append(list, serviceExtra{"status", "status", s.Status, cs.FormatStatus})
+		case ServiceIncludeNode:
+			list = append(list, serviceExtra{"node", "node", s.Node, cs.FormatNode})
+		case ServiceIncludeMeta:
+			for _, k := range sortedKeys(s.Meta) {
+				list = append(list, serviceExtra{"meta/" + k, k, s.Meta[k], cs.FormatMeta})
+			}
+		case ServiceIncludeTaggedAddresses:
+			for _, k := range sortedKeys(s.TaggedAddresses) {
+				list = append(list, serviceExtra{"tagged_addresses/" + k, k, s.TaggedAddresses[k], cs.FormatTaggedAddress})
+			}
+		}
+	}
+	return list
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// missingDependency describes a dependency which has not returned data.
+type missingDependency struct {
+	// ID is the dependency's string, such as kv.list(foo).
+	ID string
+
+	// Type is the kind of source: prefix, secret or service.
+	Type string
+
+	// Path is the prefix or secret path, or the service query.
+	Path string
+
+	// LastError is the last error reported by the watcher for the
+	// dependency, if any.
+	LastError error
+}
+
+func (m *missingDependency) String() string {
+	s := fmt.Sprintf("%s %q (%s)", m.Type, m.Path, m.ID)
+	if m.LastError != nil {
+		s += ": " + m.LastError.Error()
+	} else {
+		s += ": no data and no error returned"
+	}
+	return s
+}
+
+// ErrStartupTimeout is returned by the runner when some dependencies have not
+// returned data within the startup timeout.
+type ErrStartupTimeout struct {
+	Timeout time.Duration
+	Missing []*missingDependency
+}
+
+func (e *ErrStartupTimeout) Error() string {
+	lines := make([]string, 0, len(e.Missing))
+	for _, m := range e.Missing {
+		lines = append(lines, "  - "+m.String())
+	}
+	return fmt.Sprintf("startup timeout of %s reached, missing data for %d "+
+		"dependencies:\n%s", e.Timeout, len(e.Missing), strings.Join(lines, "\n"))
+}
+
+// ExitStatus implements the manager.ErrExitable interface.
+func (e *ErrStartupTimeout) ExitStatus() int {
+	return ExitCodeStartupTimeout
+}
+
+// defaultRetryGrace is how long after a retry is due the retryable error of a
+// dependency is kept, which is the time the retry has to fail.
+const defaultRetryGrace = 10 * time.Second
+
+// depRetry tracks a dependency whose watcher reported retryable errors.
+type depRetry struct {
+	// count is the number of retryable errors reported in a row.
+	count int
+
+	// until is when the watcher counts as having reached its server again,
+	// unless it reports another error first. The watcher reports every failed
+	// retry, and data only when it changed, so there is no other sign of it
+	// recovering.
+	until time.Time
+}
+
+// recordError records a watcher error, after which the watcher gave up,
+// against the dependency which caused it, and returns that dependency, or nil
+// if there is no match.
+func (r *Runner) recordError(err error) dep.Dependency {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	d := r.errorDependency(err)
+	if d != nil {
+		r.depErrors[d.String()] = err
+		delete(r.depRetries, d.String())
+	}
+	return d
+}
+
+// recordRetryableError records a watcher error which is retried against the
+// dependency which caused it, and returns that dependency, or nil if there is
+// no match. The error is cleared when the dependency returns data, or when
+// the retry is due and no other error was reported.
+func (r *Runner) recordRetryableError(err error) dep.Dependency {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	d := r.errorDependency(err)
+	if d == nil {
+		return nil
+	}
+
+	retry, ok := r.depRetries[d.String()]
+	if !ok || time.Now().After(retry.until) {
+		retry = &depRetry{}
+		r.depRetries[d.String()] = retry
+	}
+	var sleep time.Duration
+	if f := r.retryFunc(d); f != nil {
+		_, sleep = f(retry.count)
+	}
+	retry.count++
+	retry.until = time.Now().Add(sleep + r.retryGrace)
+	r.depErrors[d.String()] = err
+	return d
+}
+
+// clearRecovered clears the retryable errors of the dependencies whose retry
+// is due without another error. The caller must hold the dependencies lock.
+func (r *Runner) clearRecovered() {
+	now := time.Now()
+	for k, retry := range r.depRetries {
+		if now.After(retry.until) {
+			delete(r.depRetries, k)
+			delete(r.depErrors, k)
+		}
+	}
+}
+
+// errorDependency returns the dependency which caused the watcher error, or
+// nil if there is no match. Dependency errors are prefixed with the
+// dependency's string, which is how they are matched back. The caller must
+// hold the dependencies lock.
+func (r *Runner) errorDependency(err error) dep.Dependency {
+	msg := err.Error()
+	for _, d := range r.dependencies {
+		if strings.HasPrefix(msg, d.String()+":") {
+			return d
+		}
+	}
+	return nil
+}
+
+// retryFunc returns the function the watcher retries the dependency with, the
+// same way the watcher picks it.
+func (r *Runner) retryFunc(d dep.Dependency) config.RetryFunc {
+	switch d.Type() {
+	case dep.TypeConsul:
+		return r.config.Consul.Retry.RetryFunc()
+	case dep.TypeVault:
+		return r.config.Vault.Retry.RetryFunc()
+	}
+	return nil
+}
+
+// missingDependencies returns the dependencies which have not returned data
+// yet, or are missing required keys, in the order they are merged.
+func (r *Runner) missingDependencies() []*missingDependency {
+	r.dependenciesLock.Lock()
+	defer r.dependenciesLock.Unlock()
+
+	var missing []*missingDependency
+	for _, d := range r.dependencies {
+		// Dependencies with data still hold up the first start while required
+		// keys are missing.
+		_, missingKeys := r.depErrors[d.String()].(*ErrMissingKeys)
+		if _, ok := r.data[d.String()]; ok && (!missingKeys || r.env != nil) {
+			continue
+		}
+
+		// Optional dependencies never hold up the start.
+		if r.optional(d) {
+			continue
+		}
+
+		typ, path := r.source(d)
+		missing = append(missing, &missingDependency{
+			ID:        d.String(),
+			Type:      typ,
+			Path:      path,
+			LastError: r.depErrors[d.String()],
+		})
+	}
+	return missing
+}
+
+// source returns the kind of source of the dependency, prefix, secret or
+// service, and its path or query as configured.
+func (r *Runner) source(d dep.Dependency) (string, string) {
+	switch d.(type) {
+	case *dep.KVListQuery:
+		return "prefix", config.StringVal(r.configPrefixMap[d.String()].Path)
+	case *dep.VaultReadQuery:
+		return "secret", config.StringVal(r.configPrefixMap[d.String()].Path)
+	case *dep.CatalogServiceQuery, *dep.HealthServiceQuery:
+		return "service", config.StringVal(r.configServiceMap[d.String()].Query)
+	}
+	return "", d.String()
+}
@@ -0,0 +1,69 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+	"github.com/pkg/errors"
+)
+
+func TestRunner_missingDependencies(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Prefixes: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("foo")},
+			&PrefixConfig{Path: config.String("bar")},
+		},
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{Path: config.String("secret/app")},
+		},
+		Services: &ServiceConfigs{
+			&ServiceConfig{Query: config.String("web")},
+		},
+	})
+	r, err := NewRunner(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foo, err := dep.NewKVListQuery("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Receive(foo, []*dep.KeyPair{})
+
+	r.recordError(errors.Wrap(fmt.Errorf("permission denied"), "vault.read(secret/app)"))
+	r.recordError(fmt.Errorf("unrelated"))
+
+	missing := r.missingDependencies()
+	if len(missing) != 3 {
+		t.Fatalf("expected 3 missing, got %d: %v", len(missing), missing)
+	}
+
+	exp := []string{
+		`prefix "bar" (kv.list(bar)): no data and no error returned`,
+		`service "web" (catalog.service(web)): no data and no error returned`,
+		`secret "secret/app" (vault.read(secret/app)): vault.read(secret/app): permission denied`,
+	}
+	for i, m := range missing {
+		if act := m.String(); act != exp[i] {
+			t.Errorf("%d: expected %q, got %q", i, exp[i], act)
+		}
+	}
+
+	e := &ErrStartupTimeout{Timeout: 10 * time.Second, Missing: missing}
+	if !strings.Contains(e.Error(), "missing data for 3 dependencies") {
+		t.Errorf("bad error: %s", e.Error())
+	}
+	if e.ExitStatus() != ExitCodeStartupTimeout {
+		t.Errorf("bad exit status: %d", e.ExitStatus())
+	}
+}
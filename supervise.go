@@ -0,0 +1,113 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+// validSupervisePolicy returns true if the given policy is a known supervise
+// policy.
+func validSupervisePolicy(s string) bool {
+	for _, p := range SupervisePolicies {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
+
+// supervisor decides whether and when to restart a child process that exited
+// on its own. It is not safe for concurrent use.
+type supervisor struct {
+	config *SuperviseConfig
+
+	// attempt is the number of restarts since the backoff was last reset.
+	attempt int
+
+	// restarts are the times of the restarts inside the window.
+	restarts []time.Time
+
+	// lastStart is when the child was last started.
+	lastStart time.Time
+
+	// jitter returns a random duration in [0, d). It is a field so tests can
+	// make the delays predictable.
+	jitter func(d time.Duration) time.Duration
+}
+
+func newSupervisor(c *SuperviseConfig) *supervisor {
+	return &supervisor{
+		config: c,
+		jitter: func(d time.Duration) time.Duration {
+			if d <= 0 {
+				return 0
+			}
+			return time.Duration(rand.Int63n(int64(d)))
+		},
+	}
+}
+
+// started records that the child process was started at the given time.
+func (s *supervisor) started(now time.Time) {
+	s.lastStart = now
+}
+
+// restartable returns true if the policy allows restarting a child that
+// exited with the given code.
+func (s *supervisor) restartable(code int) bool {
+	switch config.StringVal(s.config.Policy) {
+	case SupervisePolicyAlways:
+		return true
+	case SupervisePolicyOnFailure:
+		return code != 0
+	default:
+		return false
+	}
+}
+
+// next returns how long to wait before restarting a child that exited at the
+// given time. It returns false if too many restarts happened inside the
+// window, in which case the supervisor gives up.
+func (s *supervisor) next(now time.Time) (time.Duration, bool) {
+	// A child that ran long enough is considered stable, so start over with
+	// the shortest delay.
+	if !s.lastStart.IsZero() && now.Sub(s.lastStart) >= config.TimeDurationVal(s.config.ResetAfter) {
+		s.attempt = 0
+	}
+
+	window := config.TimeDurationVal(s.config.Window)
+	recent := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	s.restarts = recent
+
+	if max := config.IntVal(s.config.MaxRestarts); max > 0 && len(s.restarts) >= max {
+		return 0, false
+	}
+
+	delay := config.TimeDurationVal(s.config.Backoff)
+	maxBackoff := config.TimeDurationVal(s.config.MaxBackoff)
+	for i := 0; i < s.attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	// Wait between half and all of the delay, so that several instances
+	// crashing together do not restart in lockstep.
+	delay = delay/2 + s.jitter(delay/2+1)
+
+	s.attempt++
+	s.restarts = append(s.restarts, now)
+
+	return delay, true
+}
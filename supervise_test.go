@@ -0,0 +1,128 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+)
+
+func TestSupervisor_restartable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		policy string
+		code   int
+		exp    bool
+	}{
+		{SupervisePolicyNever, 0, false},
+		{SupervisePolicyNever, 1, false},
+		{SupervisePolicyOnFailure, 0, false},
+		{SupervisePolicyOnFailure, 1, true},
+		{SupervisePolicyAlways, 0, true},
+		{SupervisePolicyAlways, 1, true},
+	}
+
+	for _, tc := range cases {
+		c := &SuperviseConfig{Policy: config.String(tc.policy)}
+		c.Finalize()
+		if act := newSupervisor(c).restartable(tc.code); act != tc.exp {
+			t.Errorf("%s with code %d: expected %t, got %t", tc.policy, tc.code, tc.exp, act)
+		}
+	}
+}
+
+func TestSupervisor_next(t *testing.T) {
+	t.Parallel()
+
+	newTestSupervisor := func() *supervisor {
+		c := &SuperviseConfig{
+			Policy:      config.String(SupervisePolicyAlways),
+			Backoff:     config.TimeDuration(2 * time.Second),
+			MaxBackoff:  config.TimeDuration(10 * time.Second),
+			ResetAfter:  config.TimeDuration(1 * time.Minute),
+			MaxRestarts: config.Int(4),
+			Window:      config.TimeDuration(10 * time.Minute),
+		}
+		c.Finalize()
+		s := newSupervisor(c)
+		// Always use the longest delay.
+		s.jitter = func(d time.Duration) time.Duration { return d - 1 }
+		return s
+	}
+
+	t.Run("backoff", func(t *testing.T) {
+		s := newTestSupervisor()
+		now := time.Now()
+		s.started(now)
+
+		exp := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+		for i, e := range exp {
+			now = now.Add(time.Second)
+			delay, ok := s.next(now)
+			if !ok {
+				t.Fatalf("restart %d: expected restart", i)
+			}
+			if delay != e {
+				t.Errorf("restart %d: expected %s, got %s", i, e, delay)
+			}
+			s.started(now.Add(delay))
+		}
+
+		// The fifth restart inside the window gives up.
+		if _, ok := s.next(now.Add(time.Second)); ok {
+			t.Fatal("expected to give up")
+		}
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		s := newTestSupervisor()
+		s.jitter = func(d time.Duration) time.Duration { return 0 }
+		delay, ok := s.next(time.Now())
+		if !ok {
+			t.Fatal("expected restart")
+		}
+		if delay != 1*time.Second {
+			t.Errorf("expected half the backoff, got %s", delay)
+		}
+	})
+
+	t.Run("reset_after_stable", func(t *testing.T) {
+		s := newTestSupervisor()
+		now := time.Now()
+		s.started(now)
+		for i := 0; i < 3; i++ {
+			if _, ok := s.next(now); !ok {
+				t.Fatal("expected restart")
+			}
+		}
+
+		// The child ran longer than reset_after, so the backoff starts over.
+		s.started(now)
+		delay, ok := s.next(now.Add(2 * time.Minute))
+		if !ok {
+			t.Fatal("expected restart")
+		}
+		if delay != 2*time.Second {
+			t.Errorf("expected backoff to reset, got %s", delay)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		s := newTestSupervisor()
+		now := time.Now()
+		for i := 0; i < 4; i++ {
+			if _, ok := s.next(now); !ok {
+				t.Fatal("expected restart")
+			}
+		}
+
+		// Restarts older than the window no longer count towards the limit.
+		if _, ok := s.next(now.Add(11 * time.Minute)); !ok {
+			t.Fatal("expected restart after the window passed")
+		}
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs returns the functions shared by the path, format and service
+// templates. The value being transformed is the last argument of each
+// function, so that it can be piped, as in `{{ key | trimPrefix "app/" }}`.
+// path returns the path of the prefix or secret, or the query of the service,
+// the template belongs to.
+func templateFuncs(path string) template.FuncMap {
+	return template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"trimPrefix": func(prefix, s string) string {
+			return strings.TrimPrefix(s, prefix)
+		},
+		"trimSuffix": func(suffix, s string) string {
+			return strings.TrimSuffix(s, suffix)
+		},
+		"replace": func(old, new, s string) string {
+			return strings.ReplaceAll(s, old, new)
+		},
+		"regexReplace": func(pattern, repl, s string) (string, error) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", err
+			}
+			return re.ReplaceAllString(s, repl), nil
+		},
+		"split": func(sep, s string) []string {
+			return strings.Split(s, sep)
+		},
+		"path": func() string {
+			return path
+		},
+	}
+}
+
+// indexFunc returns the index function of indexed service templates. Without
+// arguments, it returns the position of the instance. With arguments, it is
+// the same as the index builtin, so that it can still be used along with
+// split, as in `{{ index (split "/" key) 0 }}`.
+func indexFunc(instance int) func(...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return instance, nil
+		}
+
+		item := reflect.ValueOf(args[0])
+		for _, i := range args[1:] {
+			switch item.Kind() {
+			case reflect.Slice, reflect.Array, reflect.String:
+				n, ok := i.(int)
+				if !ok {
+					return nil, fmt.Errorf("cannot index %s with %T", item.Type(), i)
+				}
+				if n < 0 || n >= item.Len() {
+					return nil, fmt.Errorf("index out of range: %d", n)
+				}
+				item = item.Index(n)
+			case reflect.Map:
+				k := reflect.ValueOf(i)
+				if !k.IsValid() || !k.Type().AssignableTo(item.Type().Key()) {
+					return nil, fmt.Errorf("cannot index %s with %T", item.Type(), i)
+				}
+				v := item.MapIndex(k)
+				if !v.IsValid() {
+					v = reflect.Zero(item.Type().Elem())
+				}
+				item = v
+			default:
+				return nil, fmt.Errorf("cannot index %s", item.Type())
+			}
+		}
+		return item.Interface(), nil
+	}
+}
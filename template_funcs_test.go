@@ -0,0 +1,93 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"testing"
+)
+
+func TestApplyFormatTemplate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		format string
+		key    string
+		exp    string
+		err    bool
+	}{
+		{"key", "APP_{{ key }}", "port", "APP_port", false},
+		{"no_escaping", "{{ key }}", "a&b<c>'d'", "a&b<c>'d'", false},
+		{"lower", "{{ key | lower }}", "PORT", "port", false},
+		{"upper", "{{ key | upper }}", "port", "PORT", false},
+		{"trimPrefix", `{{ key | trimPrefix "db_" }}`, "db_port", "port", false},
+		{"trimSuffix", `{{ key | trimSuffix "_v2" }}`, "port_v2", "port", false},
+		{"replace", `{{ key | replace "." "_" }}`, "db.port", "db_port", false},
+		{"regexReplace", `{{ key | regexReplace "^v[0-9]+/" "" }}`, "v12/port", "port", false},
+		{"regexReplace_invalid", `{{ key | regexReplace "(" "" }}`, "port", "", true},
+		{"split_index", `{{ index (split "/" key) 1 }}`, "db/port", "port", false},
+		{"path", `{{ path | upper | replace "/" "_" }}_{{ key }}`, "port", "APP_CONFIG_port", false},
+		{"replaceKey", `{{ replaceKey key "DATABASE" "db" }}`, "db", "DATABASE", false},
+		{"parse_error", "{{ key", "port", "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := applyFormatTemplate(tc.format, "app/config", tc.key)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+			if act != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestApplyServiceTemplate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		format  string
+		service string
+		index   int
+		exp     string
+	}{
+		{"functions", `{{ service | upper }}_{{ key | upper }}`, "db", -1, "DB_PORT"},
+		{"path", `{{ path | replace "." "_" }}_{{ key }}`, "db", -1, "primary_db_port"},
+		{"index", `{{ service }}_{{ index }}_{{ key }}`, "db", 2, "db_2_port"},
+		{"index_builtin", `{{ index (split "-" service) 0 }}_{{ index }}`, "db-replica", 3, "db_3"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var act string
+			var err error
+			if tc.index < 0 {
+				act, err = applyServiceTemplate(tc.format, "primary.db", tc.service, "port")
+			} else {
+				act, err = applyIndexedServiceTemplate(tc.format, "primary.db", tc.service, "port", tc.index)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestApplyPathTemplate(t *testing.T) {
+	t.Setenv("ENVCONSUL_TEMPLATE_ENV", "Production")
+
+	act, err := applyPathTemplate(`config/{{ env "ENVCONSUL_TEMPLATE_ENV" | lower }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "config/production"; act != exp {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/consul-template/config"
+	dep "github.com/hashicorp/consul-template/dependency"
+)
+
+// configProblem is a problem found in the configuration, along with the
+// stanza it was found in, such as `prefix[1] "app"`.
+type configProblem struct {
+	Location string
+	Message  string
+}
+
+func (p *configProblem) String() string {
+	if p.Location == "" {
+		return p.Message
+	}
+	return p.Location + ": " + p.Message
+}
+
+// ErrInvalidConfig is returned when validating the configuration finds
+// problems. It lists every problem, not only the first one.
+type ErrInvalidConfig struct {
+	Problems []*configProblem
+}
+
+func (e *ErrInvalidConfig) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, "  - "+p.String())
+	}
+	problems := "problems"
+	if len(e.Problems) == 1 {
+		problems = "problem"
+	}
+	return fmt.Sprintf("invalid configuration, found %d %s:\n%s",
+		len(e.Problems), problems, strings.Join(lines, "\n"))
+}
+
+// ExitStatus implements the manager.ErrExitable interface.
+func (e *ErrInvalidConfig) ExitStatus() int {
+	return ExitCodeConfigError
+}
+
+// configValidator collects the problems found while validating a
+// configuration. Warnings are settings which are accepted for backwards
+// compatibility, but have no effect.
+type configValidator struct {
+	config   *Config
+	problems []*configProblem
+	warnings []*configProblem
+}
+
+// Validate checks a finalized configuration for problems which would only
+// show up once data is received, or not at all: templates which do not
+// compile, paths and queries which do not parse, unknown values and settings
+// which contradict each other. It returns an *ErrInvalidConfig listing every
+// problem, or nil.
+func (c *Config) Validate() error {
+	v := &configValidator{config: c}
+	v.validate()
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ErrInvalidConfig{Problems: v.problems}
+}
+
+// Warnings returns the settings of a finalized configuration which are
+// accepted, but have no effect.
+func (c *Config) Warnings() []string {
+	v := &configValidator{config: c}
+	v.validate()
+	warnings := make([]string, 0, len(v.warnings))
+	for _, w := range v.warnings {
+		warnings = append(warnings, w.String())
+	}
+	return warnings
+}
+
+// problem records a problem found in the stanza at the given location.
+func (v *configValidator) problem(location, format string, args ...interface{}) {
+	v.problems = append(v.problems, &configProblem{
+		Location: location,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// warning records a setting without effect in the stanza at the given
+// location.
+func (v *configValidator) warning(location, format string, args ...interface{}) {
+	v.warnings = append(v.warnings, &configProblem{
+		Location: location,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) validate() {
+	c := v.config
+
+	v.changeStrategy("", c.ChangeStrategy)
+	if !validConflictPolicy(config.StringVal(c.ConflictPolicy)) {
+		v.problem("", "unknown conflict_policy %q, must be one of %s",
+			config.StringVal(c.ConflictPolicy), strings.Join(ConflictPolicies, ", "))
+	}
+	if !validRedactMode(config.StringVal(c.Redact.Mode)) {
+		v.problem("redact", "unknown mode %q, must be one of %s",
+			config.StringVal(c.Redact.Mode), strings.Join(RedactModes, ", "))
+	}
+	if c.Print.Enabled() && !validPrintFormat(config.StringVal(c.Print.Format)) {
+		v.problem("print", "unknown format %q, must be one of %s",
+			config.StringVal(c.Print.Format), strings.Join(PrintFormats, ", "))
+	}
+	if !validSupervisePolicy(config.StringVal(c.Supervise.Policy)) {
+		v.problem("supervise", "unknown policy %q, must be one of %s",
+			config.StringVal(c.Supervise.Policy), strings.Join(SupervisePolicies, ", "))
+	}
+	if config.TimeDurationVal(c.Cache.Deadline) < 0 {
+		v.problem("cache", "deadline must not be negative")
+	}
+	if config.TimeDurationVal(c.Rollout.GracePeriod) < 0 {
+		v.problem("rollout", "grace_period must not be negative")
+	}
+
+	v.readiness("exec.readiness", c.Readiness)
+
+	for i, p := range *c.Prefixes {
+		v.prefix(fmt.Sprintf("prefix[%d] %q", i, config.StringVal(p.Path)), p, false)
+	}
+	for i, s := range *c.Secrets {
+		v.prefix(fmt.Sprintf("secret[%d] %q", i, config.StringVal(s.Path)), s, true)
+	}
+	for i, s := range *c.Services {
+		v.service(fmt.Sprintf("service[%d] %q", i, config.StringVal(s.Query)), s)
+	}
+	for i, r := range *c.Require {
+		v.require(fmt.Sprintf("require[%d] %q", i, config.StringVal(r.Name)), r)
+	}
+}
+
+// changeStrategy checks a top-level or per stanza change strategy, which is
+// unset in stanzas which use the top-level one.
+func (v *configValidator) changeStrategy(location string, s *string) {
+	if !config.StringPresent(s) {
+		return
+	}
+	strategy := config.StringVal(s)
+	if !validChangeStrategy(strategy) {
+		v.problem(location, "unknown change_strategy %q, must be one of %s",
+			strategy, strings.Join(ChangeStrategies, ", "))
+		return
+	}
+	if strategy != ChangeStrategySignal {
+		return
+	}
+	c := v.config
+	if c.Exec.ReloadSignal == nil || *c.Exec.ReloadSignal == nil {
+		v.problem(location, "change_strategy %q requires exec.reload_signal", strategy)
+	}
+	if !c.EnvFile.Enabled() {
+		v.problem(location, "change_strategy %q requires env_file.path", strategy)
+	}
+}
+
+// template checks that the template of the named option compiles with the
+// given functions.
+func (v *configValidator) template(location, name, contents string, funcs template.FuncMap) {
+	if _, err := template.New(name).Funcs(funcs).Parse(contents); err != nil {
+		v.problem(location, "%s template does not compile: %s", name, err)
+	}
+}
+
+// prefix checks a prefix, or a secret when secret is true.
+func (v *configValidator) prefix(location string, p *PrefixConfig, secret bool) {
+	// Entries without a path are dropped when the configuration is finalized.
+	if path, err := applyPathTemplate(config.StringVal(p.Path)); err != nil {
+		v.problem(location, "path template is invalid: %s", err)
+	} else if secret {
+		if _, err := dep.NewVaultReadQuery(path); err != nil {
+			v.problem(location, "path is invalid: %s", err)
+		}
+	} else {
+		if _, err := dep.NewKVListQuery(path); err != nil {
+			v.problem(location, "path is invalid: %s", err)
+		}
+	}
+
+	if config.StringPresent(p.Format) {
+		v.template(location, "format", config.StringVal(p.Format), formatFuncs("", ""))
+	}
+	if !validValueEncoding(config.StringVal(p.Encoding)) {
+		v.problem(location, "unknown encoding %q", config.StringVal(p.Encoding))
+	}
+	if !validDecode(config.StringVal(p.Decode)) {
+		v.problem(location, "unknown decode format %q", config.StringVal(p.Decode))
+	}
+	v.changeStrategy(location, p.ChangeStrategy)
+
+	if secret {
+		if config.StringPresent(p.Decode) {
+			v.problem(location, "decode is only used by prefixes")
+		}
+	} else {
+		if p.Files.Enabled() {
+			v.problem(location, "files is only used by secrets")
+		}
+	}
+
+	if p.Keys == nil {
+		return
+	}
+	if len(*p.Keys) > 0 && config.StringPresent(p.Format) {
+		v.warning(location, "key blocks are ignored when format is set")
+	}
+	for i, k := range *p.Keys {
+		keyLocation := fmt.Sprintf("%s key[%d] %q", location, i, config.StringVal(k.Name))
+		if !config.StringPresent(k.Name) {
+			v.problem(keyLocation, "name is required")
+		}
+		if config.StringPresent(k.Format) {
+			v.template(keyLocation, "format", config.StringVal(k.Format), formatFuncs("", ""))
+		}
+		if secret && (k.Default != nil || k.Required != nil) {
+			v.problem(keyLocation, "default and required are only used by prefixes")
+		}
+		if k.Default != nil && config.BoolVal(k.Required) {
+			v.problem(keyLocation, "required has no effect along with a default")
+		}
+	}
+}
+
+// service checks a service.
+func (v *configValidator) service(location string, s *ServiceConfig) {
+	query := config.StringVal(s.Query)
+	health := config.StringVal(s.Health)
+	switch {
+	case query == "":
+		v.problem(location, "query is required")
+	case !validServiceHealth(health):
+		v.problem(location, "unknown health %q", health)
+	case health != "":
+		if _, err := dep.NewHealthServiceQuery(query + "|" + serviceHealthFilter(health)); err != nil {
+			v.problem(location, "query is invalid: %s", err)
+		}
+	default:
+		if _, err := dep.NewCatalogServiceQuery(query); err != nil {
+			v.problem(location, "query is invalid: %s", err)
+		}
+	}
+
+	if !validServiceSelect(config.StringVal(s.Select)) {
+		v.problem(location, "unknown select strategy %q", config.StringVal(s.Select))
+	}
+	for _, include := range s.Include {
+		if !validServiceInclude(include) {
+			v.problem(location, "unknown include %q", include)
+		}
+		if include == ServiceIncludeStatus && health == "" {
+			v.problem(location, "include %q requires health to be set", include)
+		}
+	}
+	v.changeStrategy(location, s.ChangeStrategy)
+
+	formats := []struct {
+		name     string
+		contents *string
+	}{
+		{"format_id", s.FormatId},
+		{"format_name", s.FormatName},
+		{"format_address", s.FormatAddress},
+		{"format_tag", s.FormatTag},
+		{"format_port", s.FormatPort},
+		{"format_count", s.FormatCount},
+		{"format_addresses", s.FormatAddresses},
+		{"format_status", s.FormatStatus},
+		{"format_meta", s.FormatMeta},
+		{"format_node", s.FormatNode},
+		{"format_tagged_address", s.FormatTaggedAddress},
+	}
+	for _, f := range formats {
+		if config.StringPresent(f.contents) {
+			v.template(location, f.name, config.StringVal(f.contents), serviceFuncs("", "", ""))
+		}
+	}
+	if config.StringPresent(s.FormatIndexed) {
+		funcs := serviceFuncs("", "", "")
+		funcs["index"] = indexFunc(0)
+		v.template(location, "format_indexed", config.StringVal(s.FormatIndexed), funcs)
+	}
+}
+
+// require checks a require block.
+func (v *configValidator) require(location string, r *RequireConfig) {
+	if name := config.StringVal(r.Name); name == "" {
+		v.problem(location, "name is required")
+	} else if _, err := filepath.Match(name, ""); err != nil {
+		v.problem(location, "name is not a valid glob: %s", err)
+	}
+	if config.StringPresent(r.Regex) {
+		if _, err := regexp.Compile(config.StringVal(r.Regex)); err != nil {
+			v.problem(location, "regex does not compile: %s", err)
+		}
+	}
+	if !validRequireType(config.StringVal(r.Type)) {
+		v.problem(location, "unknown type %q, must be one of %s",
+			config.StringVal(r.Type), strings.Join(RequireTypes, ", "))
+	}
+}
+
+// readiness checks the readiness probe of the child process.
+func (v *configValidator) readiness(location string, c *ReadinessConfig) {
+	if c.probes() > 1 {
+		v.problem(location, "only one of http, tcp, command and file can be set")
+	}
+	if config.StringPresent(c.HTTP) {
+		u, err := url.Parse(config.StringVal(c.HTTP))
+		if err != nil {
+			v.problem(location, "http is not a valid URL: %s", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.problem(location, "http must be an http or https URL with a host")
+		}
+	}
+	if config.StringPresent(c.TCP) {
+		if _, _, err := net.SplitHostPort(config.StringVal(c.TCP)); err != nil {
+			v.problem(location, "tcp is not a valid address: %s", err)
+		}
+	}
+	if config.TimeDurationVal(c.Interval) <= 0 {
+		v.problem(location, "interval must be positive")
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/go-gatedio"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		c    *Config
+		exp  []string
+	}{
+		{
+			"valid",
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("app"), Format: config.String("APP_{{ key }}")},
+				},
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Path: config.String("secret/app"),
+						Keys: &KeyFormats{
+							&KeyFormat{Name: config.String("password"), Format: config.String("DB_PASSWORD")},
+						},
+					},
+				},
+				Services: &ServiceConfigs{
+					&ServiceConfig{Query: config.String("db"), Health: config.String("passing")},
+				},
+			},
+			nil,
+		},
+		{
+			"format_template",
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("app"), Format: config.String("APP_{{ key }")},
+				},
+			},
+			[]string{`prefix[0] "app": format template does not compile`},
+		},
+		{
+			"unknown_function",
+			&Config{
+				Services: &ServiceConfigs{
+					&ServiceConfig{Query: config.String("db"), FormatPort: config.String("{{ nope }}")},
+				},
+			},
+			[]string{`service[0] "db": format_port template does not compile`},
+		},
+		{
+			"path_template",
+			&Config{
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{Path: config.String(`secret/{{ env "ENVCONSUL_VALIDATE_MISSING" }}`)},
+				},
+			},
+			[]string{`secret[0] "secret/{{ env \"ENVCONSUL_VALIDATE_MISSING\" }}": path template is invalid`},
+		},
+		{
+			"service_query",
+			&Config{
+				Services: &ServiceConfigs{
+					&ServiceConfig{Query: config.String("db@dc1@dc2")},
+				},
+			},
+			[]string{`service[0] "db@dc1@dc2": query is invalid`},
+		},
+		{
+			"keys_with_format",
+			&Config{
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Path:   config.String("secret/app"),
+						Format: config.String("APP_{{ key }}"),
+						Keys: &KeyFormats{
+							&KeyFormat{Format: config.String("{{ key ")},
+						},
+					},
+				},
+			},
+			[]string{
+				`secret[0] "secret/app" key[0] "": name is required`,
+				`secret[0] "secret/app" key[0] "": format template does not compile`,
+			},
+		},
+		{
+			"key_default_required",
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Path: config.String("app"),
+						Keys: &KeyFormats{
+							&KeyFormat{Name: config.String("port"), Default: config.String("80"), Required: config.Bool(true)},
+						},
+					},
+				},
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{
+						Path: config.String("secret/app"),
+						Keys: &KeyFormats{
+							&KeyFormat{Name: config.String("password"), Required: config.Bool(true)},
+						},
+					},
+				},
+			},
+			[]string{
+				`prefix[0] "app" key[0] "port": required has no effect along with a default`,
+				`secret[0] "secret/app" key[0] "password": default and required are only used by prefixes`,
+			},
+		},
+		{
+			"stanza_options",
+			&Config{
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{
+						Path:  config.String("app"),
+						Files: &FilesConfig{Dir: config.String("/run/secrets")},
+					},
+				},
+				Secrets: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("secret/app"), Decode: config.String("json")},
+				},
+				Services: &ServiceConfigs{
+					&ServiceConfig{
+						Query:   config.String("db"),
+						Include: []string{"status"},
+						Select:  config.String("best"),
+					},
+				},
+			},
+			[]string{
+				`prefix[0] "app": files is only used by secrets`,
+				`secret[0] "secret/app": decode is only used by prefixes`,
+				`service[0] "db": unknown select strategy "best"`,
+				`service[0] "db": include "status" requires health to be set`,
+			},
+		},
+		{
+			"require",
+			&Config{
+				Require: &RequireConfigs{
+					&RequireConfig{Regex: config.String("^[0-9]+$")},
+					&RequireConfig{Name: config.String("DB_[*"), Type: config.String("float")},
+					&RequireConfig{Name: config.String("PORT"), Regex: config.String("([0-9]")},
+				},
+			},
+			[]string{
+				`require[0] "": name is required`,
+				`require[1] "DB_[*": name is not a valid glob`,
+				`require[1] "DB_[*": unknown type "float", must be one of int, bool, url, duration`,
+				`require[2] "PORT": regex does not compile`,
+			},
+		},
+		{
+			"readiness",
+			&Config{
+				Readiness: &ReadinessConfig{
+					HTTP:     config.String("localhost:8080/health"),
+					TCP:      config.String("localhost"),
+					Interval: config.TimeDuration(0),
+				},
+			},
+			[]string{
+				`exec.readiness: only one of http, tcp, command and file can be set`,
+				`exec.readiness: http must be an http or https URL with a host`,
+				`exec.readiness: tcp is not a valid address`,
+				`exec.readiness: interval must be positive`,
+			},
+		},
+		{
+			"options",
+			&Config{
+				Print:     &PrintConfig{Format: config.String("xml")},
+				Supervise: &SuperviseConfig{Policy: config.String("sometimes")},
+				Cache: &CacheConfig{
+					Path:     config.String("/var/cache/envconsul.json"),
+					Deadline: config.TimeDuration(-time.Second),
+				},
+				Rollout: &RolloutConfig{GracePeriod: config.TimeDuration(-time.Second)},
+			},
+			[]string{
+				`print: unknown format "xml", must be one of`,
+				`supervise: unknown policy "sometimes", must be one of never, on-failure, always`,
+				`cache: deadline must not be negative`,
+				`rollout: grace_period must not be negative`,
+			},
+		},
+		{
+			"change_strategy",
+			&Config{
+				ChangeStrategy: config.String("reboot"),
+				Prefixes: &PrefixConfigs{
+					&PrefixConfig{Path: config.String("app"), ChangeStrategy: config.String("signal")},
+				},
+			},
+			[]string{
+				`unknown change_strategy "reboot"`,
+				`prefix[0] "app": change_strategy "signal" requires exec.reload_signal`,
+				`prefix[0] "app": change_strategy "signal" requires env_file.path`,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig().Merge(tc.c)
+			c.Finalize()
+
+			err := c.Validate()
+			if tc.exp == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			typed, ok := err.(*ErrInvalidConfig)
+			if !ok {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			var act []string
+			for i, p := range typed.Problems {
+				// Only the start of problems caused by parse errors is compared.
+				if i < len(tc.exp) && strings.HasPrefix(p.String(), tc.exp[i]) {
+					act = append(act, tc.exp[i])
+					continue
+				}
+				act = append(act, p.String())
+			}
+			if !reflect.DeepEqual(act, tc.exp) {
+				t.Errorf("expected problems:\n%s\ngot:\n%s",
+					strings.Join(tc.exp, "\n"), strings.Join(act, "\n"))
+			}
+		})
+	}
+}
+
+func TestConfig_Warnings(t *testing.T) {
+	t.Parallel()
+
+	c := DefaultConfig().Merge(&Config{
+		Secrets: &PrefixConfigs{
+			&PrefixConfig{
+				Path:   config.String("secret/app"),
+				Format: config.String("APP_{{ key }}"),
+				Keys: &KeyFormats{
+					&KeyFormat{Name: config.String("password"), Format: config.String("DB_PASSWORD")},
+				},
+			},
+		},
+	})
+	c.Finalize()
+
+	// Key blocks along with a format are accepted for backwards compatibility.
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{`secret[0] "secret/app": key blocks are ignored when format is set`}
+	if act := c.Warnings(); !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected %q, got %q", exp, act)
+	}
+}
+
+func TestErrInvalidConfig_Error(t *testing.T) {
+	t.Parallel()
+
+	err := &ErrInvalidConfig{Problems: []*configProblem{
+		{Location: "print", Message: `unknown format "xml"`},
+	}}
+	if exp := "invalid configuration, found 1 problem:\n  - print: unknown format \"xml\""; err.Error() != exp {
+		t.Errorf("expected %q, got %q", exp, err.Error())
+	}
+}
+
+func TestCLI_Run_validate(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	if err := os.WriteFile(path, []byte(`prefix {
+		path   = "app"
+		format = "{{ key"
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := gatedio.NewByteBuffer()
+	cli := NewCLI(out, out)
+	if code := cli.Run([]string{"envconsul", "validate", "-config", path}); code != ExitCodeConfigError {
+		t.Errorf("expected exit code %d, got %d: %s", ExitCodeConfigError, code, out.String())
+	}
+	if exp := `prefix[0] "app": format template does not compile`; !strings.Contains(out.String(), exp) {
+		t.Errorf("expected %q in output, got %q", exp, out.String())
+	}
+
+	out = gatedio.NewByteBuffer()
+	cli = NewCLI(out, out)
+	if code := cli.Run([]string{"envconsul", "validate", "-prefix", "app"}); code != ExitCodeOK {
+		t.Errorf("expected exit code %d, got %d: %s", ExitCodeOK, code, out.String())
+	}
+}
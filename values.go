@@ -0,0 +1,143 @@
+// Copyright IBM Corp. 2014, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// validValueEncoding returns true if the given encoding is known.
+func validValueEncoding(s string) bool {
+	switch s {
+	case ValueEncodingString, ValueEncodingJSON, ValueEncodingFlatten:
+		return true
+	}
+	return false
+}
+
+// encodeValue converts a value to strings using the given encoding. The
+// result is keyed by the original key, or by the flattened keys joined with
+// sep when flattening nested objects. An empty key is not included in the
+// flattened keys.
+func encodeValue(key string, value interface{}, encoding, sep string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	var encode func(key string, value interface{}) error
+	encode = func(key string, value interface{}) error {
+		if s, ok := value.(string); ok {
+			result[key] = s
+			return nil
+		}
+
+		if value == nil {
+			return nil
+		}
+
+		if encoding == ValueEncodingString {
+			return fmt.Errorf("invalid type for value. got %v, not string",
+				reflect.TypeOf(value))
+		}
+
+		switch typed := value.(type) {
+		case bool:
+			result[key] = strconv.FormatBool(typed)
+		case json.Number:
+			result[key] = typed.String()
+		case float64:
+			result[key] = strconv.FormatFloat(typed, 'f', -1, 64)
+		case int, int64, uint64:
+			result[key] = fmt.Sprint(typed)
+		case map[string]interface{}:
+			if encoding == ValueEncodingFlatten {
+				for k, v := range typed {
+					if key != "" {
+						k = key + sep + k
+					}
+					if err := encode(k, v); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return encodeJSON(key, typed, result)
+		default:
+			return encodeJSON(key, typed, result)
+		}
+		return nil
+	}
+
+	if err := encode(key, value); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// encodeJSON stores the JSON encoding of value in result under key.
+func encodeJSON(key string, value interface{}, result map[string]string) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding value as JSON: %w", err)
+	}
+	result[key] = string(b)
+	return nil
+}
+
+// validDecode returns true if the given document format is known.
+func validDecode(s string) bool {
+	switch s {
+	case "", DecodeJSON, DecodeYAML:
+		return true
+	}
+	return false
+}
+
+// decodeValue parses a document in the given format. JSON numbers are kept
+// as json.Number so large integers are not rounded.
+func decodeValue(format, s string) (interface{}, error) {
+	var result interface{}
+	switch format {
+	case DecodeJSON:
+		dec := json.NewDecoder(bytes.NewBufferString(s))
+		dec.UseNumber()
+		if err := dec.Decode(&result); err != nil {
+			return nil, fmt.Errorf("decoding JSON: %w", err)
+		}
+	case DecodeYAML:
+		if err := yaml.Unmarshal([]byte(s), &result); err != nil {
+			return nil, fmt.Errorf("decoding YAML: %w", err)
+		}
+		result = normalizeYAML(result)
+	default:
+		return nil, fmt.Errorf("unknown decode format %q", format)
+	}
+	return result, nil
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values produced by
+// the YAML decoder to map[string]interface{}, so they can be flattened and
+// encoded as JSON.
+func normalizeYAML(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[fmt.Sprint(k)] = normalizeYAML(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(typed))
+		for i, v := range typed {
+			l[i] = normalizeYAML(v)
+		}
+		return l
+	default:
+		return v
+	}
+}